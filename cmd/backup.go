@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/onurbalmeida/k8s-cleanup/internal/backup"
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	backupPath         string
+	archiveLogsPath    string
+	archiveLogsMaxSize int64
+
+	// podLogs is set while --archive-logs is active so records can point at
+	// the archived logs.
+	podLogs *backup.PodLogs
+)
+
+// openBackup opens the --backup and --archive-logs sinks. The returned close
+// func must run after the last deletion.
+func openBackup(kube kubernetes.Interface) ([]engine.Option, func(), error) {
+	if dryRun {
+		return nil, func() {}, nil
+	}
+	var (
+		opts    []engine.Option
+		closers []func() error
+		names   []string
+	)
+	closeAll := func() {
+		for i, c := range closers {
+			if err := c(); err != nil {
+				log.Error().Err(err).Str("path", names[i]).Msg("closing backup failed")
+			}
+		}
+	}
+	if backupPath != "" {
+		sink, err := backup.Open(backupPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, engine.WithBackup(sink))
+		closers, names = append(closers, sink.Close), append(names, backupPath)
+	}
+	if archiveLogsPath != "" {
+		l, err := backup.OpenPodLogs(kube, archiveLogsPath, archiveLogsMaxSize)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		podLogs = l
+		opts = append(opts, engine.WithBackup(l))
+		closers, names = append(closers, l.Close), append(names, archiveLogsPath)
+	}
+	return opts, closeAll, nil
+}
+
+// logArchiveFor is where the logs of the named pod or job were archived,
+// empty unless they were actually saved. Other kinds sharing the name get
+// nothing.
+func logArchiveFor(kind, ns, name string) string {
+	if podLogs == nil {
+		return ""
+	}
+	loc, _ := podLogs.Archived(kind, ns, name)
+	return loc
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/controller"
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/spf13/cobra"
+)
+
+var (
+	resync      time.Duration
+	deleteDelay time.Duration
+)
+
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "Watch Pods and Jobs and delete them as they expire",
+	Long:  "Runs continuously, watching Pods and Jobs through informers and deleting each one shortly after it crosses its age threshold. Uses the same filters as run. Defaults to dry-run for safety.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd.Flags())
+		applyDefaults()
+		syncFromViper()
+
+		m, opts, stopMetrics, err := startMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+
+		kube, dyn, mapper, err := newClients()
+		if err != nil {
+			return err
+		}
+		backupOpts, closeBackup, err := openBackup(kube)
+		if err != nil {
+			return err
+		}
+		defer closeBackup()
+		defer startEvents(kube)()
+
+		eng, err := newEngine(kube, dyn, mapper, append(opts, backupOpts...)...)
+		if err != nil {
+			return err
+		}
+
+		writer, closer, err := prepareAudit(auditFile)
+		if err != nil {
+			return err
+		}
+		if closer != nil {
+			defer closer()
+		}
+
+		var mu sync.Mutex
+		ctrl, err := controller.New(eng, controller.Options{
+			Resync: resync,
+			Delay:  deleteDelay,
+			DryRun: dryRun,
+			Report: func(c engine.Candidate, res engine.DeleteResult, err error) {
+				rec := newRecord(c, eng.Now())
+				if !dryRun {
+					setDeleteResult(&rec, res, err)
+				}
+				emitEvent(cmd.Context(), c, rec)
+				logRecord(rec)
+				observeRecord(m, rec)
+				mu.Lock()
+				writeAudit(writer, rec)
+				if writer != nil {
+					_ = writer.Flush()
+				}
+				mu.Unlock()
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return ctrl.Run(ctx)
+	},
+}
+
+func init() {
+	addFilterFlags(controllerCmd.Flags())
+	controllerCmd.Flags().StringVar(&auditFile, "audit-file", "", "Write NDJSON audit events to file")
+	controllerCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (empty disables)")
+	controllerCmd.Flags().DurationVar(&resync, "resync", 10*time.Minute, "Informer resync period")
+	addDeleteRateFlags(controllerCmd.Flags())
+	addClientFlags(controllerCmd.Flags())
+	addEventFlags(controllerCmd.Flags())
+	controllerCmd.Flags().DurationVar(&deleteDelay, "delete-delay", 5*time.Second, "Grace period after an object becomes due before it is deleted")
+
+	rootCmd.AddCommand(controllerCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/events"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	eventsEnabled bool
+	eventsQPS     float64
+	eventsBurst   int
+
+	// eventRecorder is set while Kubernetes Events are emitted.
+	eventRecorder *events.Recorder
+)
+
+func addEventFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&eventsEnabled, "events", true, "Emit a Kubernetes Event on the owning CronJob, Deployment or namespace for each deletion, failed deletion and deletion mark")
+	fs.Float64Var(&eventsQPS, "events-qps", 1, "Events per second per namespace; events over the limit are dropped (0 for no limit)")
+	fs.IntVar(&eventsBurst, "events-burst", 25, "Events allowed in a burst per namespace above --events-qps")
+}
+
+// startEvents starts emitting events unless disabled or in dry-run. The
+// returned func waits briefly for events still being written.
+func startEvents(kube kubernetes.Interface) func() {
+	if !eventsEnabled || dryRun {
+		return func() {}
+	}
+	eventRecorder = events.NewForCluster(kube, eventsQPS, eventsBurst)
+	return func() {
+		eventRecorder.Close(5 * time.Second)
+		eventRecorder = nil
+	}
+}
+
+func emitEvent(ctx context.Context, c engine.Candidate, r cleanupRecord) {
+	if eventRecorder == nil || r.DryRun {
+		return
+	}
+	switch {
+	case r.Error != "":
+		eventRecorder.Failed(ctx, c, r.Error)
+	case r.Deleted:
+		eventRecorder.Deleted(ctx, c)
+	}
+}
+
+func emitMarkEvent(ctx context.Context, c engine.Candidate, at time.Time) {
+	if eventRecorder != nil {
+		eventRecorder.Scheduled(ctx, c, at)
+	}
+}
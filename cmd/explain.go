@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = &cobra.Command{
+	Use:   "explain <kind>/<name>",
+	Short: "Show why an object is or isn't a cleanup candidate",
+	Long:  "Fetches one object and walks it through every filter run applies, in order, printing each decision and the values it used. Takes the same filter flags as run.",
+	Example: `  k8s-cleanup explain pod/job-success-abc12 -n ci --older-than 24h
+  k8s-cleanup explain job/nightly-28391040 -n ci --policy policy.yaml --output json`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd.Flags())
+		applyDefaults()
+		syncFromViper()
+
+		kind, name, ok := strings.Cut(args[0], "/")
+		if !ok || kind == "" || name == "" {
+			return fmt.Errorf("want <kind>/<name>, got %q", args[0])
+		}
+		kube, dyn, mapper, err := newClients()
+		if err != nil {
+			return err
+		}
+		eng, err := newEngine(kube, dyn, mapper)
+		if err != nil {
+			return err
+		}
+		ns := namespace
+		if ns == "" {
+			ns = "default"
+		}
+		x, err := eng.Explain(cmd.Context(), kind, ns, name)
+		if err != nil {
+			return err
+		}
+		return printExplanation(cmd.OutOrStdout(), x)
+	},
+}
+
+func printExplanation(w io.Writer, x engine.Explanation) error {
+	if strings.EqualFold(output, "json") {
+		data, err := json.MarshalIndent(x, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	obj := x.Kind + "/" + x.Name
+	if x.Namespace != "" {
+		obj = x.Namespace + "/" + obj
+	}
+	verdict := "is not a candidate"
+	if x.Candidate {
+		verdict = "is a candidate"
+	}
+	fmt.Fprintf(w, "%s (%s) %s\n", obj, x.State, verdict)
+	for _, s := range x.Steps {
+		fmt.Fprintf(w, "  %-4s  %-13s  %s\n", strings.ToUpper(s.Result), s.Check, s.Detail)
+	}
+	return nil
+}
+
+func init() {
+	addFilterFlags(explainCmd.Flags())
+	explainCmd.Flags().StringVar(&output, "output", "text", "Output format: text|json")
+	addNowFlag(explainCmd.Flags())
+	addClientFlags(explainCmd.Flags())
+	rootCmd.AddCommand(explainCmd)
+}
@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	dryRun               bool
+	olderThan            string
+	kinds                []string
+	namespace            string
+	allNS                bool
+	excludeNS            []string
+	labelSelector        string
+	fieldSelector        string
+	includeCompleted     bool
+	includeFailed        bool
+	includeEvicted       bool
+	protectLabelKV       string
+	concurrency          int
+	output               string
+	auditFile            string
+	exitNonZeroOnChanges bool
+	keepRevisions        int
+	pvcIdle              string
+	pvDeleteRetained     bool
+	keepLast             int
+	keepLastLabel        string
+	policyFile           string
+	leaseName            string
+	leaseNamespace       string
+	leaseIdentity        string
+	leaseMode            string
+	leaseDuration        time.Duration
+	leaseRenewDeadline   time.Duration
+	leaseRetryPeriod     time.Duration
+	pageSize             int64
+	listMetadata         bool
+	terminatingAfter     string
+	forceTerminating     bool
+	stripFinalizers      []string
+	nowAt                string
+)
+
+// flagKeys maps config file keys to the flags that override them. Several
+// commands share these flags, so they are bound when a command runs rather
+// than at init time.
+var flagKeys = map[string]string{
+	"dryRun":                          "dry-run",
+	"olderThan":                       "older-than",
+	"kinds":                           "kind",
+	"namespace":                       "namespace",
+	"allNamespaces":                   "all-namespaces",
+	"excludeNamespaces":               "exclude-ns",
+	"labelSelector":                   "label-selector",
+	"fieldSelector":                   "field-selector",
+	"completed":                       "completed",
+	"failed":                          "failed",
+	"evicted":                         "evicted",
+	"protectLabel":                    "protect",
+	"concurrency":                     "concurrency",
+	"output":                          "output",
+	"auditFile":                       "audit-file",
+	"exitNonZeroOnChanges":            "exit-nonzero-on-changes",
+	"keepRevisions":                   "keep-revisions",
+	"pvcIdle":                         "pvc-idle",
+	"pvDeleteRetained":                "pv-delete-retained",
+	"keepLast":                        "keep-last",
+	"keepLastLabel":                   "keep-last-label",
+	"policy":                          "policy",
+	"pageSize":                        "page-size",
+	"listMetadata":                    "list-metadata",
+	"terminatingAfter":                "terminating-after",
+	"forceDeleteTerminating":          "force-delete-terminating",
+	"stripFinalizers":                 "strip-finalizers",
+	"metricsAddr":                     "metrics-addr",
+	"backup":                          "backup",
+	"archiveLogs":                     "archive-logs",
+	"archiveLogsMaxBytes":             "archive-logs-max-bytes",
+	"pushgateway.url":                 "pushgateway-url",
+	"pushgateway.job":                 "pushgateway-job",
+	"pushgateway.instance":            "pushgateway-instance",
+	"lease.name":                      "lease-name",
+	"lease.namespace":                 "lease-namespace",
+	"lease.identity":                  "lease-identity",
+	"lease.mode":                      "lease-mode",
+	"lease.duration":                  "lease-duration",
+	"lease.renewDeadline":             "lease-renew-deadline",
+	"lease.retryPeriod":               "lease-retry-period",
+	"limits.maxDeletions":             "max-deletions",
+	"limits.maxDeletionsPerNamespace": "max-deletions-per-namespace",
+	"limits.maxDeletionPercent":       "max-deletion-percent",
+	"limits.action":                   "limit-action",
+	"delete.qps":                      "delete-qps",
+	"delete.burst":                    "delete-burst",
+	"delete.retries":                  "delete-retries",
+	"delete.retryBackoff":             "delete-retry-backoff",
+	"kubeAPI.qps":                     "kube-api-qps",
+	"kubeAPI.burst":                   "kube-api-burst",
+	"events.enabled":                  "events",
+	"noticePeriod":                    "notice-period",
+	"events.qps":                      "events-qps",
+	"events.burst":                    "events-burst",
+}
+
+func addFilterFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&dryRun, "dry-run", true, "Simulate without deleting")
+	fs.StringVar(&olderThan, "older-than", "24h", "Age threshold (e.g., 30m, 24h, 7d)")
+	fs.StringSliceVar(&kinds, "kind", []string{"pod", "job"}, "Resource kinds: pod,job,replicaset,configmap,secret,pvc,pv or any resource[.group] (e.g. workflows.argoproj.io)")
+	fs.StringVarP(&namespace, "namespace", "n", "default", "Target namespace")
+	fs.BoolVar(&allNS, "all-namespaces", false, "Process all namespaces")
+	fs.StringSliceVar(&excludeNS, "exclude-ns", []string{"kube-system", "kube-public"}, "Namespaces to exclude")
+	fs.StringVar(&labelSelector, "label-selector", "", "Label selector")
+	fs.StringVar(&fieldSelector, "field-selector", "", "Field selector")
+	fs.BoolVar(&includeCompleted, "completed", true, "Include Completed/Succeeded")
+	fs.BoolVar(&includeFailed, "failed", true, "Include Failed")
+	fs.BoolVar(&includeEvicted, "evicted", true, "Include Evicted (pods)")
+	fs.StringVar(&protectLabelKV, "protect", "keep=true", "Protect resources with this label (key[=value])")
+	fs.IntVar(&keepRevisions, "keep-revisions", 0, "Always keep the newest N old ReplicaSets per Deployment (replicaset kind)")
+	fs.StringVar(&pvcIdle, "pvc-idle", "", "Select bound PVCs no pod has mounted for this long (pvc kind, empty disables)")
+	fs.BoolVar(&pvDeleteRetained, "pv-delete-retained", false, "Also select Released/Failed PVs whose reclaim policy is Retain (pv kind)")
+	fs.IntVar(&keepLast, "keep-last", 0, "Always keep the N most recent completed and N most recent failed per owner")
+	fs.StringVar(&keepLastLabel, "keep-last-label", "", "Label that groups ownerless resources for --keep-last")
+	fs.StringVar(&policyFile, "policy", "", "Policy file (YAML) with ordered cleanup rules; first matching rule wins")
+	fs.Int64Var(&pageSize, "page-size", 500, "Objects fetched per list call for pods, jobs and custom resources")
+	fs.BoolVar(&listMetadata, "list-metadata", false, "List pods and jobs as metadata only and fetch just those old enough to be candidates")
+	fs.StringVar(&terminatingAfter, "terminating-after", "", "Select pods stuck Terminating this long past their deletionTimestamp (empty disables)")
+	fs.BoolVar(&forceTerminating, "force-delete-terminating", false, "Force delete (grace period 0) selected Terminating pods whose node is NotReady or gone")
+	fs.StringSliceVar(&stripFinalizers, "strip-finalizers", nil, "Remove these finalizers from selected Terminating pods")
+	fs.StringVar(&backupPath, "backup", "", "Save each object's manifest to this directory or .tar.gz archive before deleting it")
+	fs.StringVar(&archiveLogsPath, "archive-logs", "", "Save container logs of each pod, and of the pods of each job, to this directory or .tar.gz archive before deleting it")
+	fs.Int64Var(&archiveLogsMaxSize, "archive-logs-max-bytes", 10<<20, "Keep at most this many bytes of log per container (0 for no limit)")
+}
+
+func bindFlags(fs *pflag.FlagSet) {
+	for key, name := range flagKeys {
+		if f := fs.Lookup(name); f != nil {
+			_ = viper.BindPFlag(key, f)
+		}
+	}
+}
+
+func applyDefaults() {
+	viper.SetDefault("dryRun", true)
+	viper.SetDefault("olderThan", "24h")
+	viper.SetDefault("kinds", []string{"pod", "job"})
+	viper.SetDefault("allNamespaces", false)
+	viper.SetDefault("excludeNamespaces", []string{"kube-system", "kube-public"})
+	viper.SetDefault("completed", true)
+	viper.SetDefault("failed", true)
+	viper.SetDefault("evicted", true)
+	viper.SetDefault("protectLabel", "keep=true")
+	viper.SetDefault("concurrency", 10)
+	viper.SetDefault("output", "text")
+	viper.SetDefault("auditFile", "")
+	viper.SetDefault("exitNonZeroOnChanges", false)
+	viper.SetDefault("keepRevisions", 0)
+	viper.SetDefault("pvcIdle", "")
+	viper.SetDefault("pvDeleteRetained", false)
+	viper.SetDefault("keepLast", 0)
+	viper.SetDefault("keepLastLabel", "")
+	viper.SetDefault("policy", "")
+	viper.SetDefault("pageSize", 500)
+	viper.SetDefault("listMetadata", false)
+	viper.SetDefault("terminatingAfter", "")
+	viper.SetDefault("forceDeleteTerminating", false)
+	viper.SetDefault("metricsAddr", "")
+	viper.SetDefault("backup", "")
+	viper.SetDefault("archiveLogs", "")
+	viper.SetDefault("archiveLogsMaxBytes", 10<<20)
+	viper.SetDefault("pushgateway.url", "")
+	viper.SetDefault("pushgateway.job", "k8s-cleanup")
+	viper.SetDefault("lease.name", "")
+	viper.SetDefault("lease.namespace", "default")
+	viper.SetDefault("lease.mode", "wait")
+	viper.SetDefault("lease.duration", 15*time.Second)
+	viper.SetDefault("lease.renewDeadline", 10*time.Second)
+	viper.SetDefault("lease.retryPeriod", 2*time.Second)
+	viper.SetDefault("limits.action", "abort")
+	viper.SetDefault("delete.burst", 1)
+	viper.SetDefault("delete.retries", 5)
+	viper.SetDefault("delete.retryBackoff", time.Second)
+	viper.SetDefault("events.enabled", true)
+	viper.SetDefault("noticePeriod", time.Duration(0))
+	viper.SetDefault("events.qps", 1)
+	viper.SetDefault("events.burst", 25)
+}
+
+func syncFromViper() {
+	dryRun = viper.GetBool("dryRun")
+	olderThan = viper.GetString("olderThan")
+	kinds = viper.GetStringSlice("kinds")
+	namespace = viper.GetString("namespace")
+	allNS = viper.GetBool("allNamespaces")
+	excludeNS = viper.GetStringSlice("excludeNamespaces")
+	labelSelector = viper.GetString("labelSelector")
+	fieldSelector = viper.GetString("fieldSelector")
+	includeCompleted = viper.GetBool("completed")
+	includeFailed = viper.GetBool("failed")
+	includeEvicted = viper.GetBool("evicted")
+	protectLabelKV = viper.GetString("protectLabel")
+	concurrency = viper.GetInt("concurrency")
+	output = viper.GetString("output")
+	auditFile = viper.GetString("auditFile")
+	exitNonZeroOnChanges = viper.GetBool("exitNonZeroOnChanges")
+	keepRevisions = viper.GetInt("keepRevisions")
+	pvcIdle = viper.GetString("pvcIdle")
+	pvDeleteRetained = viper.GetBool("pvDeleteRetained")
+	keepLast = viper.GetInt("keepLast")
+	keepLastLabel = viper.GetString("keepLastLabel")
+	policyFile = viper.GetString("policy")
+	pageSize = viper.GetInt64("pageSize")
+	listMetadata = viper.GetBool("listMetadata")
+	terminatingAfter = viper.GetString("terminatingAfter")
+	forceTerminating = viper.GetBool("forceDeleteTerminating")
+	stripFinalizers = viper.GetStringSlice("stripFinalizers")
+	metricsAddr = viper.GetString("metricsAddr")
+	backupPath = viper.GetString("backup")
+	archiveLogsPath = viper.GetString("archiveLogs")
+	archiveLogsMaxSize = viper.GetInt64("archiveLogsMaxBytes")
+	pushgatewayURL = viper.GetString("pushgateway.url")
+	pushgatewayJob = viper.GetString("pushgateway.job")
+	pushgatewayInstance = viper.GetString("pushgateway.instance")
+	leaseName = viper.GetString("lease.name")
+	leaseNamespace = viper.GetString("lease.namespace")
+	leaseIdentity = viper.GetString("lease.identity")
+	leaseMode = viper.GetString("lease.mode")
+	leaseDuration = viper.GetDuration("lease.duration")
+	leaseRenewDeadline = viper.GetDuration("lease.renewDeadline")
+	leaseRetryPeriod = viper.GetDuration("lease.retryPeriod")
+	maxDeletions = viper.GetInt("limits.maxDeletions")
+	maxDeletionsPerNamespace = viper.GetInt("limits.maxDeletionsPerNamespace")
+	maxDeletionPercent = viper.GetFloat64("limits.maxDeletionPercent")
+	limitAction = viper.GetString("limits.action")
+	deleteQPS = viper.GetFloat64("delete.qps")
+	deleteBurst = viper.GetInt("delete.burst")
+	deleteRetries = viper.GetInt("delete.retries")
+	deleteRetryBackoff = viper.GetDuration("delete.retryBackoff")
+	kubeAPIQPS = float32(viper.GetFloat64("kubeAPI.qps"))
+	kubeAPIBurst = viper.GetInt("kubeAPI.burst")
+	eventsEnabled = viper.GetBool("events.enabled")
+	noticePeriod = viper.GetDuration("noticePeriod")
+	eventsQPS = viper.GetFloat64("events.qps")
+	eventsBurst = viper.GetInt("events.burst")
+}
+
+func engineConfig() (engine.Config, error) {
+	dur, err := time.ParseDuration(olderThan)
+	if err != nil {
+		return engine.Config{}, fmt.Errorf("invalid --older-than: %w", err)
+	}
+
+	var idle time.Duration
+	if pvcIdle != "" {
+		if idle, err = time.ParseDuration(pvcIdle); err != nil {
+			return engine.Config{}, fmt.Errorf("invalid --pvc-idle: %w", err)
+		}
+	}
+
+	var terminating time.Duration
+	if terminatingAfter != "" {
+		if terminating, err = time.ParseDuration(terminatingAfter); err != nil {
+			return engine.Config{}, fmt.Errorf("invalid --terminating-after: %w", err)
+		}
+	}
+
+	var rules []engine.Rule
+	if policyFile != "" {
+		if rules, err = engine.LoadPolicy(policyFile); err != nil {
+			return engine.Config{}, err
+		}
+	}
+
+	pk, pv := helpers.ParseKV(protectLabelKV)
+
+	nsList := []string(nil)
+	if !allNS {
+		if namespace == "" {
+			nsList = []string{"default"}
+		} else {
+			nsList = []string{namespace}
+		}
+	}
+
+	return engine.Config{
+		OlderThan:              dur,
+		Kinds:                  kinds,
+		AllNamespaces:          allNS,
+		Namespaces:             nsList,
+		ExcludeNamespaces:      excludeNS,
+		LabelSelector:          labelSelector,
+		FieldSelector:          fieldSelector,
+		IncludeCompleted:       includeCompleted,
+		IncludeFailed:          includeFailed,
+		IncludeEvicted:         includeEvicted,
+		ProtectKey:             pk,
+		ProtectVal:             pv,
+		KeepRevisions:          keepRevisions,
+		PVCIdle:                idle,
+		DeleteRetainedPVs:      pvDeleteRetained,
+		KeepLast:               keepLast,
+		KeepLastLabel:          keepLastLabel,
+		Rules:                  rules,
+		PageSize:               pageSize,
+		TerminatingAfter:       terminating,
+		ForceDeleteTerminating: forceTerminating,
+		StripFinalizers:        stripFinalizers,
+	}, nil
+}
+
+func addNowFlag(fs *pflag.FlagSet) {
+	fs.StringVar(&nowAt, "now", "", "Evaluate ages as of this RFC3339 time instead of the current time (dry-run only)")
+}
+
+// parseNow parses --now for commands that talk to a cluster, where it only
+// works with --dry-run. The zero time means it is not set.
+func parseNow() (time.Time, error) {
+	t, err := parseNowFlag()
+	if err != nil || t.IsZero() {
+		return t, err
+	}
+	if !dryRun {
+		return time.Time{}, fmt.Errorf("--now only works with --dry-run")
+	}
+	return t, nil
+}
+
+// parseNowFlag parses --now without the --dry-run check, for simulate, which
+// never deletes anything.
+func parseNowFlag() (time.Time, error) {
+	if nowAt == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, nowAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --now: %w", err)
+	}
+	return t, nil
+}
+
+func newEngine(cs kubernetes.Interface, dyn dynamic.Interface, mapper meta.RESTMapper, opts ...engine.Option) (*engine.Engine, error) {
+	ecfg, err := engineConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(append([]engine.Option{engine.WithDynamic(dyn, mapper)}, deleteRateOptions()...), opts...)
+	now, err := parseNow()
+	if err != nil {
+		return nil, err
+	}
+	if !now.IsZero() {
+		opts = append(opts, engine.WithNow(now))
+	}
+	if listMetadata {
+		cfg, err := clientConfig()
+		if err != nil {
+			return nil, err
+		}
+		mc, err := metadata.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, engine.WithMetadata(mc))
+	}
+	eng := engine.New(cs, ecfg, opts...)
+	if scannedKinds, err = eng.Kinds(); err != nil {
+		return nil, err
+	}
+	return eng, nil
+}
+
+func newClients() (kubernetes.Interface, dynamic.Interface, meta.RESTMapper, error) {
+	cfg, err := clientConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(cs.Discovery()))
+	return cs, dyn, mapper, nil
+}
+
+func clientConfig() (*rest.Config, error) {
+	loading := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loading, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	applyClientLimits(cfg)
+	return cfg, nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/lease"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/kubernetes"
+)
+
+// leaseHolder is the identity holding the lease during this run, recorded in
+// audit events. Empty when locking is disabled.
+var leaseHolder string
+
+func addLeaseFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&leaseName, "lease-name", "", "Hold this Lease while running so overlapping runs never delete concurrently (empty disables)")
+	fs.StringVar(&leaseNamespace, "lease-namespace", "default", "Namespace of the Lease")
+	fs.StringVar(&leaseIdentity, "lease-identity", "", "Identity recorded in the Lease (default hostname-pid)")
+	fs.StringVar(&leaseMode, "lease-mode", "wait", "When the Lease is held: wait|skip (exit 4)|fail (exit 5)")
+	fs.DurationVar(&leaseDuration, "lease-duration", 15*time.Second, "Lease duration")
+	fs.DurationVar(&leaseRenewDeadline, "lease-renew-deadline", 10*time.Second, "Deadline for renewing the Lease")
+	fs.DurationVar(&leaseRetryPeriod, "lease-retry-period", 2*time.Second, "Interval between Lease acquire and renew attempts")
+}
+
+// withLease runs fn while holding the configured Lease, or directly when no
+// lease name is set.
+func withLease(ctx context.Context, kube kubernetes.Interface, fn func(ctx context.Context) error) error {
+	if leaseName == "" {
+		return fn(ctx)
+	}
+	mode := strings.ToLower(leaseMode)
+	switch mode {
+	case "wait", "skip", "fail":
+	default:
+		return fmt.Errorf("invalid --lease-mode %q: want wait, skip or fail", leaseMode)
+	}
+	id := leaseIdentity
+	if id == "" {
+		host, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	cfg := lease.Config{
+		Namespace:     leaseNamespace,
+		Name:          leaseName,
+		Identity:      id,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: leaseRenewDeadline,
+		RetryPeriod:   leaseRetryPeriod,
+		Wait:          mode == "wait",
+	}
+	if mode == "wait" {
+		log.Info().Str("lease", leaseNamespace+"/"+leaseName).Str("identity", id).Msg("waiting for lease")
+	}
+	err := lease.Run(ctx, kube, cfg, func(ctx context.Context) error {
+		leaseHolder = id
+		log.Info().Str("lease", leaseNamespace+"/"+leaseName).Str("identity", id).Msg("lease acquired")
+		return fn(ctx)
+	})
+	var held *lease.HeldError
+	if errors.As(err, &held) {
+		if mode == "skip" {
+			log.Warn().Str("lease", leaseNamespace+"/"+leaseName).Str("holder", held.Holder).Msg("lease held by another instance, skipping")
+			setExitCode(4)
+			return nil
+		}
+		setExitCode(5)
+	}
+	return err
+}
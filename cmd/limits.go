@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+)
+
+var (
+	maxDeletions             int
+	maxDeletionsPerNamespace int
+	maxDeletionPercent       float64
+	limitAction              string
+)
+
+func addLimitFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&maxDeletions, "max-deletions", 0, "Refuse to delete more than this many objects in one run (0 disables)")
+	fs.IntVar(&maxDeletionsPerNamespace, "max-deletions-per-namespace", 0, "Refuse to delete more than this many objects per namespace in one run (0 disables)")
+	fs.Float64Var(&maxDeletionPercent, "max-deletion-percent", 0, "Refuse to delete more than this percentage of the listed objects of a kind (0 disables)")
+	fs.StringVar(&limitAction, "limit-action", "abort", "When a deletion limit is exceeded: abort (exit 6, delete nothing)|truncate (delete the oldest candidates within the limits, exit 6)")
+}
+
+func limitsEnabled() bool {
+	return maxDeletions > 0 || maxDeletionsPerNamespace > 0 || maxDeletionPercent > 0
+}
+
+// enforceLimits applies the deletion limits to cands. It returns the
+// candidates to process, or ok=false when the run must stop without deleting.
+func enforceLimits(cands []engine.Candidate, listed map[string]int) ([]engine.Candidate, bool, error) {
+	limits := engine.Limits{
+		MaxDeletions:             maxDeletions,
+		MaxDeletionsPerNamespace: maxDeletionsPerNamespace,
+		MaxDeletionPercent:       maxDeletionPercent,
+	}
+	action := strings.ToLower(limitAction)
+	if action != "abort" && action != "truncate" {
+		return nil, false, fmt.Errorf("invalid --limit-action %q: want abort or truncate", limitAction)
+	}
+	err := limits.Check(cands, listed)
+	var le *engine.LimitError
+	if !errors.As(err, &le) {
+		return cands, true, err
+	}
+	setExitCode(6)
+	if action == "abort" {
+		log.Error().Str("limit", le.Limit).Str("scope", le.Scope).Int("count", le.Count).Int("max", le.Max).Int("candidates", len(cands)).Msg("deletion limit exceeded, nothing deleted")
+		return nil, false, nil
+	}
+	kept, dropped := limits.Truncate(cands, listed)
+	for _, c := range dropped {
+		log.Debug().Str("kind", c.Kind).Str("ns", c.Namespace).Str("name", c.Name).Dur("age", c.Age).Msg("over deletion limit, left for a later run")
+	}
+	log.Warn().Str("limit", le.Limit).Str("scope", le.Scope).Int("kept", len(kept)).Int("dropped", len(dropped)).Msg("deletion limit exceeded, truncated to the oldest candidates")
+	return kept, true, nil
+}
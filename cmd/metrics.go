@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	metricsAddr         string
+	pushgatewayURL      string
+	pushgatewayJob      string
+	pushgatewayInstance string
+
+	// scannedKinds are the kinds the engine lists, so the pushed summary has
+	// a series for each even when a run finds nothing of it.
+	scannedKinds []string
+)
+
+// startMetrics serves /metrics on --metrics-addr. It returns nil metrics and
+// no options when the flag is unset.
+func startMetrics() (*metrics.Metrics, []engine.Option, func(), error) {
+	if metricsAddr == "" {
+		return nil, nil, func() {}, nil
+	}
+	m := metrics.New()
+	stop, err := m.Serve(metricsAddr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	log.Info().Str("addr", metricsAddr).Msg("serving metrics")
+	return m, []engine.Option{engine.WithObserver(m)}, stop, nil
+}
+
+// errRetried is the outcome recorded for a delete call that was retried.
+var errRetried = errors.New("retried")
+
+// observeRecord counts r as a candidate and every delete call it took as an
+// attempt. Calls that were retried, and a last call that did not delete the
+// object, e.g. a precondition conflict or NotFound, count as failed.
+func observeRecord(m *metrics.Metrics, r cleanupRecord) {
+	if m == nil {
+		return
+	}
+	m.Candidate(r.Resource, r.Namespace, r.State)
+	if r.DryRun || r.Mark != "" || r.calls == 0 {
+		return
+	}
+	for i := 1; i < r.calls; i++ {
+		m.Deletion(r.Resource, r.Namespace, r.State, errRetried)
+	}
+	var err error
+	switch {
+	case r.Error != "":
+		err = errors.New(r.Error)
+	case !r.Deleted:
+		err = errors.New(r.Skipped)
+	}
+	m.Deletion(r.Resource, r.Namespace, r.State, err)
+}
+
+// pushSummary sends the run summary to --pushgateway-url. Failures are logged
+// rather than failing the run. The instance defaults to the job name rather
+// than the hostname: CronJob pods get a new hostname every run, and each
+// would leave a group behind serving stale series.
+func pushSummary(s metrics.Summary) {
+	if pushgatewayURL == "" {
+		return
+	}
+	instance := pushgatewayInstance
+	if instance == "" {
+		instance = pushgatewayJob
+	}
+	if err := metrics.PushSummary(pushgatewayURL, pushgatewayJob, instance, s); err != nil {
+		log.Warn().Err(err).Str("url", pushgatewayURL).Msg("push to pushgateway failed")
+		return
+	}
+	log.Debug().Str("url", pushgatewayURL).Str("job", pushgatewayJob).Str("instance", instance).Msg("pushed run summary")
+}
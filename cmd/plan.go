@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	planVersion       = 2
+	planConfigVersion = 1
+)
+
+// planFile is what plan writes and apply reads. The engine config is stored
+// so apply can tell whether a changed object still matches without the
+// original flags.
+type planFile struct {
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Config    planConfig `json:"config"`
+	// Listed counts listed objects per kind for --max-deletion-percent.
+	Listed map[string]int `json:"listed,omitempty"`
+	Items  []planItem     `json:"items"`
+}
+
+type planItem struct {
+	Kind            string                 `json:"kind"`
+	Namespace       string                 `json:"namespace,omitempty"`
+	Name            string                 `json:"name"`
+	UID             types.UID              `json:"uid"`
+	ResourceVersion string                 `json:"resourceVersion"`
+	State           string                 `json:"state"`
+	Age             time.Duration          `json:"age"`
+	Rule            string                 `json:"rule,omitempty"`
+	TTLSource       string                 `json:"ttlSource,omitempty"`
+	Owner           *metav1.OwnerReference `json:"owner,omitempty"`
+}
+
+// planConfig is engine.Config as stored in a plan. It has its own version so
+// apply refuses a config it does not know rather than silently dropping
+// fields. Durations are written like --older-than.
+type planConfig struct {
+	Version                int        `json:"version"`
+	OlderThan              string     `json:"olderThan,omitempty"`
+	Kinds                  []string   `json:"kinds,omitempty"`
+	AllNamespaces          bool       `json:"allNamespaces,omitempty"`
+	Namespaces             []string   `json:"namespaces,omitempty"`
+	ExcludeNamespaces      []string   `json:"excludeNamespaces,omitempty"`
+	LabelSelector          string     `json:"labelSelector,omitempty"`
+	FieldSelector          string     `json:"fieldSelector,omitempty"`
+	IncludeCompleted       bool       `json:"includeCompleted,omitempty"`
+	IncludeFailed          bool       `json:"includeFailed,omitempty"`
+	IncludeEvicted         bool       `json:"includeEvicted,omitempty"`
+	ProtectKey             string     `json:"protectKey,omitempty"`
+	ProtectVal             string     `json:"protectValue,omitempty"`
+	KeepRevisions          int        `json:"keepRevisions,omitempty"`
+	PVCIdle                string     `json:"pvcIdle,omitempty"`
+	DeleteRetainedPVs      bool       `json:"deleteRetainedPVs,omitempty"`
+	KeepLast               int        `json:"keepLast,omitempty"`
+	KeepLastLabel          string     `json:"keepLastLabel,omitempty"`
+	Rules                  []planRule `json:"rules,omitempty"`
+	PageSize               int64      `json:"pageSize,omitempty"`
+	TerminatingAfter       string     `json:"terminatingAfter,omitempty"`
+	ForceDeleteTerminating bool       `json:"forceDeleteTerminating,omitempty"`
+	StripFinalizers        []string   `json:"stripFinalizers,omitempty"`
+}
+
+type planRule struct {
+	Name              string   `json:"name"`
+	Namespaces        []string `json:"namespaces,omitempty"`
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	LabelSelector     string   `json:"labelSelector,omitempty"`
+	Kinds             []string `json:"kinds,omitempty"`
+	States            []string `json:"states,omitempty"`
+	OlderThan         string   `json:"olderThan,omitempty"`
+	ProtectKey        string   `json:"protectKey,omitempty"`
+	ProtectVal        string   `json:"protectValue,omitempty"`
+}
+
+func newPlanConfig(cfg engine.Config) planConfig {
+	c := planConfig{
+		Version:                planConfigVersion,
+		OlderThan:              planDuration(cfg.OlderThan),
+		Kinds:                  cfg.Kinds,
+		AllNamespaces:          cfg.AllNamespaces,
+		Namespaces:             cfg.Namespaces,
+		ExcludeNamespaces:      cfg.ExcludeNamespaces,
+		LabelSelector:          cfg.LabelSelector,
+		FieldSelector:          cfg.FieldSelector,
+		IncludeCompleted:       cfg.IncludeCompleted,
+		IncludeFailed:          cfg.IncludeFailed,
+		IncludeEvicted:         cfg.IncludeEvicted,
+		ProtectKey:             cfg.ProtectKey,
+		ProtectVal:             cfg.ProtectVal,
+		KeepRevisions:          cfg.KeepRevisions,
+		PVCIdle:                planDuration(cfg.PVCIdle),
+		DeleteRetainedPVs:      cfg.DeleteRetainedPVs,
+		KeepLast:               cfg.KeepLast,
+		KeepLastLabel:          cfg.KeepLastLabel,
+		PageSize:               cfg.PageSize,
+		TerminatingAfter:       planDuration(cfg.TerminatingAfter),
+		ForceDeleteTerminating: cfg.ForceDeleteTerminating,
+		StripFinalizers:        cfg.StripFinalizers,
+	}
+	for _, r := range cfg.Rules {
+		c.Rules = append(c.Rules, planRule{
+			Name:              r.Name,
+			Namespaces:        r.Namespaces,
+			ExcludeNamespaces: r.ExcludeNamespaces,
+			LabelSelector:     r.LabelSelector,
+			Kinds:             r.Kinds,
+			States:            r.States,
+			OlderThan:         planDuration(r.OlderThan),
+			ProtectKey:        r.ProtectKey,
+			ProtectVal:        r.ProtectVal,
+		})
+	}
+	return c
+}
+
+// engineConfig maps c back to the engine config it was made from.
+func (c planConfig) engineConfig() (engine.Config, error) {
+	if c.Version != planConfigVersion {
+		return engine.Config{}, fmt.Errorf("unsupported plan config version %d", c.Version)
+	}
+	cfg := engine.Config{
+		Kinds:                  c.Kinds,
+		AllNamespaces:          c.AllNamespaces,
+		Namespaces:             c.Namespaces,
+		ExcludeNamespaces:      c.ExcludeNamespaces,
+		LabelSelector:          c.LabelSelector,
+		FieldSelector:          c.FieldSelector,
+		IncludeCompleted:       c.IncludeCompleted,
+		IncludeFailed:          c.IncludeFailed,
+		IncludeEvicted:         c.IncludeEvicted,
+		ProtectKey:             c.ProtectKey,
+		ProtectVal:             c.ProtectVal,
+		KeepRevisions:          c.KeepRevisions,
+		DeleteRetainedPVs:      c.DeleteRetainedPVs,
+		KeepLast:               c.KeepLast,
+		KeepLastLabel:          c.KeepLastLabel,
+		PageSize:               c.PageSize,
+		ForceDeleteTerminating: c.ForceDeleteTerminating,
+		StripFinalizers:        c.StripFinalizers,
+	}
+	var err error
+	for _, d := range []struct {
+		name  string
+		value string
+		to    *time.Duration
+	}{
+		{"olderThan", c.OlderThan, &cfg.OlderThan},
+		{"pvcIdle", c.PVCIdle, &cfg.PVCIdle},
+		{"terminatingAfter", c.TerminatingAfter, &cfg.TerminatingAfter},
+	} {
+		if *d.to, err = parsePlanDuration(d.value); err != nil {
+			return engine.Config{}, fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+	}
+	for _, pr := range c.Rules {
+		r := engine.Rule{
+			Name:              pr.Name,
+			Namespaces:        pr.Namespaces,
+			ExcludeNamespaces: pr.ExcludeNamespaces,
+			LabelSelector:     pr.LabelSelector,
+			Kinds:             pr.Kinds,
+			States:            pr.States,
+			ProtectKey:        pr.ProtectKey,
+			ProtectVal:        pr.ProtectVal,
+		}
+		if r.OlderThan, err = parsePlanDuration(pr.OlderThan); err != nil {
+			return engine.Config{}, fmt.Errorf("rule %q: invalid olderThan: %w", r.Name, err)
+		}
+		cfg.Rules = append(cfg.Rules, r)
+	}
+	return cfg, nil
+}
+
+func planDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
+
+func parsePlanDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+var (
+	planOut     string
+	applyDryRun bool
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Save the current candidates to a plan file for apply",
+	Long:  "Finds candidates like run but deletes nothing. The plan file records each object's UID and resourceVersion so apply deletes exactly those objects.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd.Flags())
+		applyDefaults()
+		syncFromViper()
+		if planOut == "" {
+			return fmt.Errorf("--out is required")
+		}
+
+		kube, dyn, mapper, err := newClients()
+		if err != nil {
+			return err
+		}
+		eng, err := newEngine(kube, dyn, mapper)
+		if err != nil {
+			return err
+		}
+		cands, err := eng.FindCandidates(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if err := writePlan(planOut, newPlan(eng.Config(), cands, eng.Listed(), time.Now())); err != nil {
+			return err
+		}
+		log.Info().Int("candidates", len(cands)).Str("path", planOut).Msg("plan written")
+		return nil
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan.json>",
+	Short: "Delete the objects recorded in a plan file",
+	Long:  "Deletes exactly the objects listed by plan. Objects that are gone, were re-created with a new UID, or changed so they no longer match are skipped and reported.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		loadApplyFlags(cmd.Flags())
+
+		p, err := readPlan(args[0])
+		if err != nil {
+			return err
+		}
+		cfg, err := p.Config.engineConfig()
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+
+		m, opts, stopMetrics, err := startMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+
+		kube, dyn, mapper, err := newClients()
+		if err != nil {
+			return err
+		}
+		backupOpts, closeBackup, err := openBackup(kube)
+		if err != nil {
+			return err
+		}
+		defer closeBackup()
+		defer startEvents(kube)()
+
+		opts = append(append([]engine.Option{engine.WithDynamic(dyn, mapper)}, deleteRateOptions()...), append(opts, backupOpts...)...)
+		eng := engine.New(kube, cfg, opts...)
+
+		cands, ok, err := enforceLimits(p.candidates(), p.Listed)
+		if !ok {
+			return err
+		}
+		return withLease(cmd.Context(), kube, func(ctx context.Context) error {
+			return processCandidates(ctx, sliceSource(cands), deleteAction(eng, true), m, time.Now())
+		})
+	},
+}
+
+// loadApplyFlags is bindFlags, applyDefaults and syncFromViper for apply,
+// which deletes by default: dry-run comes from --dry-run when set, then from
+// the config file, and is off otherwise.
+func loadApplyFlags(fs *pflag.FlagSet) {
+	bindFlags(fs)
+	applyDefaults()
+	viper.SetDefault("dryRun", false)
+	syncFromViper()
+}
+
+func newPlan(cfg engine.Config, cands []engine.Candidate, listed map[string]int, now time.Time) planFile {
+	p := planFile{Version: planVersion, CreatedAt: now.UTC(), Config: newPlanConfig(cfg), Listed: listed, Items: make([]planItem, 0, len(cands))}
+	for _, c := range cands {
+		p.Items = append(p.Items, planItem{
+			Kind:            c.Kind,
+			Namespace:       c.Namespace,
+			Name:            c.Name,
+			UID:             c.UID,
+			ResourceVersion: c.ResourceVersion,
+			State:           c.State,
+			Age:             c.Age,
+			Rule:            c.Rule,
+			TTLSource:       c.TTLSource,
+			Owner:           c.Owner,
+		})
+	}
+	return p
+}
+
+func (p planFile) candidates() []engine.Candidate {
+	out := make([]engine.Candidate, 0, len(p.Items))
+	for _, it := range p.Items {
+		out = append(out, engine.Candidate{
+			Kind:            it.Kind,
+			Namespace:       it.Namespace,
+			Name:            it.Name,
+			UID:             it.UID,
+			ResourceVersion: it.ResourceVersion,
+			State:           it.State,
+			Age:             it.Age,
+			Rule:            it.Rule,
+			TTLSource:       it.TTLSource,
+			Owner:           it.Owner,
+		})
+	}
+	return out
+}
+
+func writePlan(path string, p planFile) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+func readPlan(path string) (planFile, error) {
+	var p planFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+	// Check the version first: fields of other versions are unknown below.
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	if v.Version != planVersion {
+		return p, fmt.Errorf("%s: unsupported plan version %d", path, v.Version)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return p, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func init() {
+	addFilterFlags(planCmd.Flags())
+	planCmd.Flags().StringVar(&planOut, "out", "", "Write the plan to this file")
+	addClientFlags(planCmd.Flags())
+	rootCmd.AddCommand(planCmd)
+
+	fs := applyCmd.Flags()
+	fs.BoolVar(&applyDryRun, "dry-run", false, "Recheck and report without deleting")
+	fs.IntVar(&concurrency, "concurrency", 10, "Concurrent deletions")
+	fs.StringVar(&output, "output", "text", "Output format: text|json")
+	fs.StringVar(&auditFile, "audit-file", "", "Write NDJSON audit events to file")
+	fs.StringVar(&backupPath, "backup", "", "Save each object's manifest to this directory or .tar.gz archive before deleting it")
+	fs.StringVar(&archiveLogsPath, "archive-logs", "", "Save container logs of each pod, and of the pods of each job, to this directory or .tar.gz archive before deleting it")
+	fs.Int64Var(&archiveLogsMaxSize, "archive-logs-max-bytes", 10<<20, "Keep at most this many bytes of log per container (0 for no limit)")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (empty disables)")
+	addLeaseFlags(fs)
+	addLimitFlags(fs)
+	addDeleteRateFlags(fs)
+	addClientFlags(fs)
+	addEventFlags(fs)
+	rootCmd.AddCommand(applyCmd)
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_Apply_SkipsRecreatedObjects(t *testing.T) {
+	started := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	pod := func(name, uid string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(uid), ResourceVersion: "1"},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded, StartTime: &started},
+		}
+	}
+	kube := fake.NewSimpleClientset(pod("a", "uid-a"), pod("b", "uid-b"))
+	cfg := engine.Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"default"}, IncludeCompleted: true}
+	cands, err := engine.New(kube, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := writePlan(path, newPlan(cfg, cands, nil, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	p, err := readPlan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Items) != 2 || p.Items[0].UID == "" {
+		t.Fatalf("unexpected plan items: %+v", p.Items)
+	}
+	planned, err := p.Config.engineConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b is deleted and re-created between plan and apply.
+	pods := kube.CoreV1().Pods("default")
+	if err := pods.Delete(context.Background(), "b", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pods.Create(context.Background(), pod("b", "uid-b2"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dryRun, concurrency, auditFile, output = false, 1, "", "text"
+	defer func() { dryRun, exitCode = true, 0 }()
+	if err := processCandidates(context.Background(), sliceSource(p.candidates()), deleteAction(engine.New(kube, planned), true), nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pods.Get(context.Background(), "a", metav1.GetOptions{}); err == nil {
+		t.Fatal("a should have been deleted")
+	}
+	if _, err := pods.Get(context.Background(), "b", metav1.GetOptions{}); err != nil {
+		t.Fatalf("re-created b must survive: %v", err)
+	}
+	if exitCode != 2 {
+		t.Fatalf("exit code %d, want 2", exitCode)
+	}
+}
+
+func Test_PlanConfig_RoundTrip(t *testing.T) {
+	cfg := engine.Config{
+		OlderThan:              24 * time.Hour,
+		Kinds:                  []string{"pod", "job"},
+		AllNamespaces:          true,
+		Namespaces:             []string{"ci"},
+		ExcludeNamespaces:      []string{"kube-system"},
+		LabelSelector:          "team=data",
+		FieldSelector:          "status.phase=Succeeded",
+		IncludeCompleted:       true,
+		IncludeFailed:          true,
+		IncludeEvicted:         true,
+		ProtectKey:             "keep",
+		ProtectVal:             "true",
+		KeepRevisions:          3,
+		PVCIdle:                90 * time.Minute,
+		DeleteRetainedPVs:      true,
+		KeepLast:               2,
+		KeepLastLabel:          "app",
+		Rules:                  []engine.Rule{{Name: "ci", Namespaces: []string{"ci"}, OlderThan: 6 * time.Hour}},
+		PageSize:               100,
+		TerminatingAfter:       time.Hour,
+		ForceDeleteTerminating: true,
+		StripFinalizers:        []string{"example.com/hold"},
+	}
+	// Every field is set, so a field newPlanConfig does not map fails below.
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Fatalf("set Config.%s in this test", v.Type().Field(i).Name)
+		}
+	}
+	data, err := json.Marshal(newPlanConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pc planConfig
+	if err := json.Unmarshal(data, &pc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := pc.engineConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Fatalf("round trip\n got %+v\nwant %+v", got, cfg)
+	}
+
+	pc.Version = planConfigVersion + 1
+	if _, err := pc.engineConfig(); err == nil {
+		t.Fatal("expected an unknown config version to be rejected")
+	}
+}
+
+func Test_ReadPlan_RejectsUnknownVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, []byte(`{"version":1,"config":{"OlderThan":3600000000000},"items":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPlan(path); err == nil || !strings.Contains(err.Error(), "unsupported plan version 1") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func Test_LoadApplyFlags_DryRun(t *testing.T) {
+	defer func() { viper.Reset(); dryRun = true }()
+	for _, tc := range []struct {
+		config string
+		args   []string
+		want   bool
+	}{
+		{"", nil, false},
+		{"dryRun: true\n", nil, true},
+		{"dryRun: true\n", []string{"--dry-run=false"}, false},
+		{"", []string{"--dry-run"}, true},
+	} {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(tc.config)); err != nil {
+			t.Fatal(err)
+		}
+		fs := pflag.NewFlagSet("apply", pflag.ContinueOnError)
+		fs.Bool("dry-run", false, "")
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		loadApplyFlags(fs)
+		if dryRun != tc.want {
+			t.Errorf("config %q, args %v: dryRun %t, want %t", tc.config, tc.args, dryRun, tc.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/backup"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+var (
+	restoreFrom      string
+	restoreNamespace string
+	restoreName      string
+	restoreKind      string
+	restoreDryRun    bool
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Re-create objects from a backup taken by run --backup",
+	Long:  "Reads manifests from a backup directory or tar.gz archive and re-creates the selected objects. Objects that already exist are left alone. Defaults to dry-run for safety.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if restoreFrom == "" {
+			return fmt.Errorf("--from is required")
+		}
+		entries, err := backup.Read(restoreFrom)
+		if errors.Is(err, backup.ErrTruncated) {
+			log.Warn().Err(err).Int("entries", len(entries)).Msg("restoring the entries saved before the archive was cut off")
+		} else if err != nil {
+			return err
+		}
+		filter := backup.Filter{Namespace: restoreNamespace, Name: restoreName, Kind: restoreKind}
+
+		var restore func(e backup.Entry) error
+		if !restoreDryRun {
+			_, dyn, mapper, err := newClients()
+			if err != nil {
+				return err
+			}
+			restore = func(e backup.Entry) error {
+				return backup.Restore(cmd.Context(), dyn, mapper, e.Object)
+			}
+		}
+
+		matched, restored, errs := 0, 0, 0
+		for _, e := range entries {
+			if !filter.Match(e) {
+				continue
+			}
+			matched++
+			ev := log.Info().Str("kind", e.Kind).Str("ns", e.Object.GetNamespace()).Str("name", e.Object.GetName())
+			if restoreDryRun {
+				ev.Msg("would restore")
+				continue
+			}
+			err := restore(e)
+			switch {
+			case apierrors.IsAlreadyExists(err):
+				ev.Msg("already exists, skipped")
+			case err != nil:
+				errs++
+				log.Error().Err(err).Str("kind", e.Kind).Str("ns", e.Object.GetNamespace()).Str("name", e.Object.GetName()).Msg("restore failed")
+			default:
+				restored++
+				ev.Msg("restored")
+			}
+		}
+		log.Info().Int("matched", matched).Int("restored", restored).Int("errors", errs).Bool("dryRun", restoreDryRun).Msg("restore finished")
+
+		if errs > 0 {
+			setExitCode(3)
+		} else if restored > 0 {
+			setExitCode(2)
+		}
+		return nil
+	},
+}
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreFrom, "from", "", "Backup directory or .tar.gz archive written by --backup")
+	restoreCmd.Flags().StringVar(&restoreNamespace, "namespace", "", "Only restore objects from this namespace")
+	restoreCmd.Flags().StringVar(&restoreName, "name", "", "Only restore objects with this name")
+	restoreCmd.Flags().StringVar(&restoreKind, "kind", "", "Only restore objects of this kind (e.g. job, configmap)")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", true, "List what would be restored without creating anything")
+
+	rootCmd.AddCommand(restoreCmd)
+}
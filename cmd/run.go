@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -11,233 +12,315 @@ import (
 	"time"
 
 	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
-	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	"github.com/onurbalmeida/k8s-cleanup/internal/metrics"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type cleanupRecord struct {
-	Resource  string        `json:"resource"`
-	Namespace string        `json:"namespace"`
-	Name      string        `json:"name"`
-	State     string        `json:"state"`
-	Age       time.Duration `json:"age"`
-	Deleted   bool          `json:"deleted"`
-	DryRun    bool          `json:"dryRun"`
-	Error     string        `json:"error,omitempty"`
-	Timestamp time.Time     `json:"ts"`
-}
+	Resource    string        `json:"resource"`
+	Namespace   string        `json:"namespace"`
+	Name        string        `json:"name"`
+	State       string        `json:"state"`
+	Age         time.Duration `json:"age"`
+	Rule        string        `json:"rule,omitempty"`
+	TTLSource   string        `json:"ttlSource,omitempty"`
+	LeaseHolder string        `json:"leaseHolder,omitempty"`
+	LogArchive  string        `json:"logArchive,omitempty"`
+	Skipped     string        `json:"skipped,omitempty"`
+	Retries     int           `json:"retries,omitempty"`
+	Actions     []string      `json:"actions,omitempty"`
+	// Mark is "added" or "removed" for --notice-period records that change
+	// the scheduled-deletion mark instead of deleting.
+	Mark              string     `json:"mark,omitempty"`
+	ScheduledDeletion *time.Time `json:"scheduledDeletion,omitempty"`
+	Deleted           bool       `json:"deleted"`
+	DryRun            bool       `json:"dryRun"`
+	Error             string     `json:"error,omitempty"`
+	Timestamp         time.Time  `json:"ts"`
 
-var (
-	dryRun               bool
-	olderThan            string
-	kinds                []string
-	namespace            string
-	allNS                bool
-	excludeNS            []string
-	labelSelector        string
-	fieldSelector        string
-	includeCompleted     bool
-	includeFailed        bool
-	includeEvicted       bool
-	protectLabelKV       string
-	concurrency          int
-	output               string
-	auditFile            string
-	exitNonZeroOnChanges bool
-)
+	// calls is how many delete calls reached the API server, for metrics.
+	calls int
+}
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Scan and delete old Pods and Jobs",
 	Long:  "Scans namespaces and deletes Pods/Jobs that match filters and exceed the given age threshold. Defaults to dry-run for safety.",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd.Flags())
 		applyDefaults()
 		syncFromViper()
 
-		dur, err := time.ParseDuration(olderThan)
+		m, opts, stopMetrics, err := startMetrics()
 		if err != nil {
-			return fmt.Errorf("invalid --older-than: %w", err)
+			return err
 		}
+		defer stopMetrics()
 
-		cfg, err := clientConfig()
+		kube, dyn, mapper, err := newClients()
 		if err != nil {
 			return err
 		}
-		cs, err := kubernetes.NewForConfig(cfg)
+		backupOpts, closeBackup, err := openBackup(kube)
 		if err != nil {
 			return err
 		}
+		defer closeBackup()
+		defer startEvents(kube)()
 
-		pk, pv := helpers.ParseKV(protectLabelKV)
-
-		nsList := []string(nil)
-		if !allNS {
-			if namespace == "" {
-				nsList = []string{"default"}
-			} else {
-				nsList = []string{namespace}
-			}
+		eng, err := newEngine(kube, dyn, mapper, append(opts, backupOpts...)...)
+		if err != nil {
+			return err
 		}
 
-		eng := engine.New(cs, engine.Config{
-			OlderThan:         dur,
-			Kinds:             kinds,
-			AllNamespaces:     allNS,
-			Namespaces:        nsList,
-			ExcludeNamespaces: excludeNS,
-			LabelSelector:     labelSelector,
-			FieldSelector:     fieldSelector,
-			IncludeCompleted:  includeCompleted,
-			IncludeFailed:     includeFailed,
-			IncludeEvicted:    includeEvicted,
-			ProtectKey:        pk,
-			ProtectVal:        pv,
+		return withLease(cmd.Context(), kube, func(ctx context.Context) error {
+			return runCleanup(ctx, eng, m)
 		})
+	},
+}
 
-		cands, err := eng.FindCandidates(cmd.Context())
-		if err != nil {
-			return err
-		}
+// runCleanup streams candidates to the workers while listing. Deletion limits
+// must see every candidate before anything is deleted, so with limits set the
+// candidates are collected first. With --notice-period candidates are marked
+// instead, see runMarkSweep.
+func runCleanup(ctx context.Context, eng *engine.Engine, m *metrics.Metrics) error {
+	if noticePeriod > 0 {
+		return runMarkSweep(ctx, eng, m)
+	}
+	start := time.Now()
+	if !limitsEnabled() {
+		return processCandidates(ctx, eng.StreamCandidates, deleteAction(eng, false), m, start)
+	}
+	cands, err := eng.FindCandidates(ctx)
+	if err != nil {
+		return err
+	}
+	cands, ok, err := enforceLimits(cands, eng.Listed())
+	if !ok {
+		return err
+	}
+	return processCandidates(ctx, sliceSource(cands), deleteAction(eng, false), m, start)
+}
 
-		writer, closer, err := prepareAudit(auditFile)
-		if err != nil {
-			return err
-		}
-		if closer != nil {
-			defer closer()
-		}
+// candidateSource hands candidates to emit until it is done or emit fails.
+type candidateSource func(ctx context.Context, emit func(engine.Candidate) error) error
 
-		if concurrency < 1 {
-			concurrency = 1
+func sliceSource(cands []engine.Candidate) candidateSource {
+	return func(ctx context.Context, emit func(engine.Candidate) error) error {
+		for _, c := range cands {
+			if err := emit(c); err != nil {
+				return err
+			}
 		}
-		workCh := make(chan engine.Candidate)
-		resCh := make(chan cleanupRecord)
-		var wg sync.WaitGroup
+		return nil
+	}
+}
+
+// candidateAction handles one candidate and returns its record.
+type candidateAction func(ctx context.Context, c engine.Candidate) cleanupRecord
 
-		for i := 0; i < concurrency; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for c := range workCh {
-					rec := cleanupRecord{
-						Resource:  c.Kind,
-						Namespace: c.Namespace,
-						Name:      c.Name,
-						State:     c.State,
-						Age:       c.Age,
-						DryRun:    dryRun,
-						Deleted:   false,
-						Timestamp: time.Now(),
-					}
-					if dryRun {
-						resCh <- rec
-						continue
-					}
-					if err := eng.Delete(context.Background(), c); err != nil {
-						rec.Error = err.Error()
-						resCh <- rec
-						continue
-					}
-					rec.Deleted = true
-					resCh <- rec
+// deleteAction deletes each candidate, or only reports it in dry-run. With
+// recheck every candidate is verified against the live object first and
+// skipped if it changed.
+func deleteAction(eng *engine.Engine, recheck bool) candidateAction {
+	return func(ctx context.Context, c engine.Candidate) cleanupRecord {
+		rec := newRecord(c, eng.Now())
+		if recheck {
+			var reason string
+			var err error
+			c, reason, err = eng.Recheck(ctx, c)
+			if err != nil || reason != "" {
+				if err != nil {
+					rec.Error = err.Error()
 				}
-			}()
+				rec.Skipped = reason
+				return rec
+			}
+		}
+		if dryRun {
+			return rec
 		}
+		res, err := eng.DeleteDetailed(ctx, c)
+		setDeleteResult(&rec, res, err)
+		emitEvent(ctx, c, rec)
+		return rec
+	}
+}
 
+// processCandidates runs act on the candidates from src with --concurrency
+// workers and writes logs, audit, metrics and output.
+func processCandidates(ctx context.Context, src candidateSource, act candidateAction, m *metrics.Metrics, start time.Time) error {
+	writer, closer, err := prepareAudit(auditFile)
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer()
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	workCh := make(chan engine.Candidate)
+	resCh := make(chan cleanupRecord)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
 		go func() {
-			for _, c := range cands {
-				workCh <- c
+			defer wg.Done()
+			for c := range workCh {
+				resCh <- act(ctx, c)
 			}
-			close(workCh)
-			wg.Wait()
-			close(resCh)
 		}()
+	}
 
-		errs, deleted := 0, 0
-		var results []cleanupRecord
-		for r := range resCh {
-			results = append(results, r)
-			if r.Error != "" {
-				errs++
-				log.Error().Str("kind", r.Resource).Str("ns", r.Namespace).Str("name", r.Name).Str("state", r.State).Dur("age", r.Age).Msg("delete failed")
-			} else if r.DryRun {
-				log.Info().Str("kind", r.Resource).Str("ns", r.Namespace).Str("name", r.Name).Str("state", r.State).Dur("age", r.Age).Msg("would delete")
-			} else if r.Deleted {
-				deleted++
-				log.Info().Str("kind", r.Resource).Str("ns", r.Namespace).Str("name", r.Name).Str("state", r.State).Dur("age", r.Age).Msg("deleted")
-			}
-			if writer != nil {
-				enc, _ := json.Marshal(r)
-				_, _ = writer.Write(enc)
-				_, _ = writer.WriteString("\n")
+	var srcErr error
+	go func() {
+		srcErr = src(ctx, func(c engine.Candidate) error {
+			select {
+			case workCh <- c:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-		}
-		if writer != nil {
-			_ = writer.Flush()
-		}
+		})
+		close(workCh)
+		wg.Wait()
+		close(resCh)
+	}()
 
-		switch strings.ToLower(output) {
-		case "json":
-			data, _ := json.MarshalIndent(results, "", "  ")
-			fmt.Println(string(data))
-		default:
+	errs, changed := 0, 0
+	summary := metrics.Summary{DryRun: dryRun}
+	summary.AddKinds(scannedKinds...)
+	var results []cleanupRecord
+	for r := range resCh {
+		results = append(results, r)
+		if r.Error != "" {
+			errs++
+		} else if r.Deleted || (r.Mark != "" && r.Skipped == "") {
+			changed++
 		}
+		logRecord(r)
+		writeAudit(writer, r)
+		observeRecord(m, r)
+		summary.Add(r.Resource, r.Deleted, r.Error != "")
+	}
+	// A source error means listing stopped partway; the run failed even if
+	// every candidate it produced was handled.
+	summary.Failed = srcErr != nil
+	summary.Finished = time.Now()
+	summary.Duration = summary.Finished.Sub(start)
+	pushSummary(summary)
+	if m != nil && errs == 0 && srcErr == nil {
+		m.RunSucceeded(time.Now())
+	}
+	if writer != nil {
+		_ = writer.Flush()
+	}
 
-		if dryRun && exitNonZeroOnChanges && len(cands) > 0 {
-			setExitCode(2)
-		} else if !dryRun && errs > 0 {
-			setExitCode(3)
-		} else if !dryRun && deleted > 0 {
-			setExitCode(2)
-		}
-		return nil
-	},
+	switch strings.ToLower(output) {
+	case "json":
+		data, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(data))
+	default:
+	}
+
+	if srcErr != nil {
+		return srcErr
+	}
+	if dryRun && exitNonZeroOnChanges && len(results) > 0 {
+		setExitCode(2)
+	} else if !dryRun && errs > 0 {
+		setExitCode(3)
+	} else if !dryRun && changed > 0 {
+		setExitCode(2)
+	}
+	return nil
 }
 
-func applyDefaults() {
-	viper.SetDefault("dryRun", true)
-	viper.SetDefault("olderThan", "24h")
-	viper.SetDefault("kinds", []string{"pod", "job"})
-	viper.SetDefault("allNamespaces", false)
-	viper.SetDefault("excludeNamespaces", []string{"kube-system", "kube-public"})
-	viper.SetDefault("completed", true)
-	viper.SetDefault("failed", true)
-	viper.SetDefault("evicted", true)
-	viper.SetDefault("protectLabel", "keep=true")
-	viper.SetDefault("concurrency", 10)
-	viper.SetDefault("output", "text")
-	viper.SetDefault("auditFile", "")
-	viper.SetDefault("exitNonZeroOnChanges", false)
+// newRecord starts the record for c. now is the engine clock, so dry-runs with
+// --now are stamped with the simulated time.
+func newRecord(c engine.Candidate, now time.Time) cleanupRecord {
+	return cleanupRecord{
+		Resource:    c.Kind,
+		Namespace:   c.Namespace,
+		Name:        c.Name,
+		State:       c.State,
+		Age:         c.Age,
+		Rule:        c.Rule,
+		TTLSource:   c.TTLSource,
+		LeaseHolder: leaseHolder,
+		DryRun:      dryRun,
+		Deleted:     false,
+		Timestamp:   now,
+	}
 }
 
-func syncFromViper() {
-	dryRun = viper.GetBool("dryRun")
-	olderThan = viper.GetString("olderThan")
-	kinds = viper.GetStringSlice("kinds")
-	namespace = viper.GetString("namespace")
-	allNS = viper.GetBool("allNamespaces")
-	excludeNS = viper.GetStringSlice("excludeNamespaces")
-	labelSelector = viper.GetString("labelSelector")
-	fieldSelector = viper.GetString("fieldSelector")
-	includeCompleted = viper.GetBool("completed")
-	includeFailed = viper.GetBool("failed")
-	includeEvicted = viper.GetBool("evicted")
-	protectLabelKV = viper.GetString("protectLabel")
-	concurrency = viper.GetInt("concurrency")
-	output = viper.GetString("output")
-	auditFile = viper.GetString("auditFile")
-	exitNonZeroOnChanges = viper.GetBool("exitNonZeroOnChanges")
+// setDeleteResult records the outcome of a delete. Objects that changed since
+// they were listed, and Terminating pods nothing could be done for, are
+// reported as skipped rather than failed.
+func setDeleteResult(r *cleanupRecord, res engine.DeleteResult, err error) {
+	r.Retries = res.Retries
+	r.Actions = res.Actions
+	r.calls = res.Calls
+	r.LogArchive = logArchiveFor(r.Resource, r.Namespace, r.Name)
+	switch {
+	case errors.Is(err, engine.ErrChanged):
+		r.Skipped = engine.SkipChanged
+	case errors.Is(err, engine.ErrStillTerminating):
+		r.Skipped = engine.SkipStillTerminating
+	case err != nil:
+		r.Error = err.Error()
+	default:
+		r.Deleted = true
+	}
 }
 
-func clientConfig() (*rest.Config, error) {
-	loading := clientcmd.NewDefaultClientConfigLoadingRules()
-	overrides := &clientcmd.ConfigOverrides{}
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loading, overrides).ClientConfig()
+func logRecord(r cleanupRecord) {
+	var ev *zerolog.Event
+	msg := ""
+	switch {
+	case r.Error != "":
+		ev, msg = log.Error().Str("error", r.Error), "delete failed"
+	case r.Skipped != "":
+		ev, msg = log.Warn().Str("reason", r.Skipped), "skipped"
+	case r.Mark == "added" && r.DryRun:
+		ev, msg = log.Info(), "would mark for deletion"
+	case r.Mark == "added":
+		ev, msg = log.Info(), "marked for deletion"
+	case r.Mark == "removed" && r.DryRun:
+		ev, msg = log.Info(), "would remove mark"
+	case r.Mark == "removed":
+		ev, msg = log.Info(), "mark removed"
+	case r.DryRun:
+		ev, msg = log.Info(), "would delete"
+	case r.Deleted:
+		ev, msg = log.Info(), "deleted"
+	default:
+		return
+	}
+	if r.Retries > 0 {
+		ev.Int("retries", r.Retries)
+	}
+	if len(r.Actions) > 0 {
+		ev.Strs("actions", r.Actions)
+	}
+	if r.ScheduledDeletion != nil {
+		ev.Time("scheduledDeletion", *r.ScheduledDeletion)
+	}
+	ev.Str("kind", r.Resource).Str("ns", r.Namespace).Str("name", r.Name).Str("state", r.State).Dur("age", r.Age).Str("rule", r.Rule).Str("ttlSource", r.TTLSource).Msg(msg)
+}
+
+func writeAudit(w *bufio.Writer, r cleanupRecord) {
+	if w == nil {
+		return
+	}
+	enc, _ := json.Marshal(r)
+	_, _ = w.Write(enc)
+	_, _ = w.WriteString("\n")
 }
 
 func prepareAudit(path string) (*bufio.Writer, func(), error) {
@@ -257,39 +340,22 @@ func prepareAudit(path string) (*bufio.Writer, func(), error) {
 }
 
 func init() {
-	runCmd.Flags().BoolVar(&dryRun, "dry-run", true, "Simulate without deleting")
-	runCmd.Flags().StringVar(&olderThan, "older-than", "24h", "Age threshold (e.g., 30m, 24h, 7d)")
-	runCmd.Flags().StringSliceVar(&kinds, "kind", []string{"pod", "job"}, "Resource kinds: pod,job")
-	runCmd.Flags().StringVar(&namespace, "namespace", "default", "Target namespace")
-	runCmd.Flags().BoolVar(&allNS, "all-namespaces", false, "Process all namespaces")
-	runCmd.Flags().StringSliceVar(&excludeNS, "exclude-ns", []string{"kube-system", "kube-public"}, "Namespaces to exclude")
-	runCmd.Flags().StringVar(&labelSelector, "label-selector", "", "Label selector")
-	runCmd.Flags().StringVar(&fieldSelector, "field-selector", "", "Field selector")
-	runCmd.Flags().BoolVar(&includeCompleted, "completed", true, "Include Completed/Succeeded")
-	runCmd.Flags().BoolVar(&includeFailed, "failed", true, "Include Failed")
-	runCmd.Flags().BoolVar(&includeEvicted, "evicted", true, "Include Evicted (pods)")
-	runCmd.Flags().StringVar(&protectLabelKV, "protect", "keep=true", "Protect resources with this label (key[=value])")
+	addFilterFlags(runCmd.Flags())
 	runCmd.Flags().IntVar(&concurrency, "concurrency", 10, "Concurrent deletions")
 	runCmd.Flags().StringVar(&output, "output", "text", "Output format: text|json")
 	runCmd.Flags().StringVar(&auditFile, "audit-file", "", "Write NDJSON audit events to file")
 	runCmd.Flags().BoolVar(&exitNonZeroOnChanges, "exit-nonzero-on-changes", false, "Exit with code 2 if there are candidates (dry-run)")
-
-	_ = viper.BindPFlag("dryRun", runCmd.Flags().Lookup("dry-run"))
-	_ = viper.BindPFlag("olderThan", runCmd.Flags().Lookup("older-than"))
-	_ = viper.BindPFlag("kinds", runCmd.Flags().Lookup("kind"))
-	_ = viper.BindPFlag("namespace", runCmd.Flags().Lookup("namespace"))
-	_ = viper.BindPFlag("allNamespaces", runCmd.Flags().Lookup("all-namespaces"))
-	_ = viper.BindPFlag("excludeNamespaces", runCmd.Flags().Lookup("exclude-ns"))
-	_ = viper.BindPFlag("labelSelector", runCmd.Flags().Lookup("label-selector"))
-	_ = viper.BindPFlag("fieldSelector", runCmd.Flags().Lookup("field-selector"))
-	_ = viper.BindPFlag("completed", runCmd.Flags().Lookup("completed"))
-	_ = viper.BindPFlag("failed", runCmd.Flags().Lookup("failed"))
-	_ = viper.BindPFlag("evicted", runCmd.Flags().Lookup("evicted"))
-	_ = viper.BindPFlag("protectLabel", runCmd.Flags().Lookup("protect"))
-	_ = viper.BindPFlag("concurrency", runCmd.Flags().Lookup("concurrency"))
-	_ = viper.BindPFlag("output", runCmd.Flags().Lookup("output"))
-	_ = viper.BindPFlag("auditFile", runCmd.Flags().Lookup("audit-file"))
-	_ = viper.BindPFlag("exitNonZeroOnChanges", runCmd.Flags().Lookup("exit-nonzero-on-changes"))
+	runCmd.Flags().StringVar(&pushgatewayURL, "pushgateway-url", "", "Push a run summary to this Prometheus Pushgateway when the run ends")
+	runCmd.Flags().StringVar(&pushgatewayJob, "pushgateway-job", "k8s-cleanup", "Job label for --pushgateway-url")
+	runCmd.Flags().StringVar(&pushgatewayInstance, "pushgateway-instance", "", "Instance label for --pushgateway-url (default the job label, so each run replaces the last)")
+	runCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090 (empty disables)")
+	addLeaseFlags(runCmd.Flags())
+	addLimitFlags(runCmd.Flags())
+	addDeleteRateFlags(runCmd.Flags())
+	addClientFlags(runCmd.Flags())
+	addEventFlags(runCmd.Flags())
+	addNowFlag(runCmd.Flags())
+	runCmd.Flags().DurationVar(&noticePeriod, "notice-period", 0, "Mark candidates for deletion this far ahead instead of deleting them; later runs delete expired marks that still match (0 deletes right away)")
 
 	rootCmd.AddCommand(runCmd)
 }
@@ -2,8 +2,19 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/backup"
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/metrics"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func Test_Root_Help_ShowsCommands(t *testing.T) {
@@ -15,7 +26,7 @@ func Test_Root_Help_ShowsCommands(t *testing.T) {
 		t.Fatalf("root help execute: %v", err)
 	}
 	out := buf.String()
-	for _, want := range []string{"run", "version", "completion"} {
+	for _, want := range []string{"run", "plan", "apply", "explain", "simulate", "controller", "restore", "version", "completion"} {
 		if !strings.Contains(out, want) {
 			t.Fatalf("root help missing %q\n%s", want, out)
 		}
@@ -36,9 +47,134 @@ func Test_Run_Help_ShowsFlags(t *testing.T) {
 		"--all-namespaces", "--exclude-ns", "--label-selector",
 		"--field-selector", "--completed", "--failed", "--evicted",
 		"--protect", "--concurrency", "--output", "--audit-file",
+		"--lease-name", "--lease-mode", "--max-deletions", "--limit-action", "--now",
 	} {
 		if !strings.Contains(out, want) {
 			t.Fatalf("run help missing flag %q\n%s", want, out)
 		}
 	}
 }
+
+func Test_WithLease_SkipsWhenHeld(t *testing.T) {
+	holder := "other"
+	secs := int32(60)
+	now := metav1.NewMicroTime(time.Now())
+	kube := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cleanup"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &secs,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	})
+	leaseName, leaseNamespace, leaseIdentity = "cleanup", "default", "me"
+	leaseDuration, leaseRenewDeadline, leaseRetryPeriod = time.Second, 500*time.Millisecond, 100*time.Millisecond
+	defer func() { leaseName, exitCode = "", 0 }()
+
+	for _, tc := range []struct {
+		mode    string
+		code    int
+		wantErr bool
+	}{
+		{"skip", 4, false},
+		{"fail", 5, true},
+	} {
+		exitCode = 0
+		leaseMode = tc.mode
+		err := withLease(context.Background(), kube, func(ctx context.Context) error {
+			t.Fatalf("%s: fn must not run while the lease is held", tc.mode)
+			return nil
+		})
+		if (err != nil) != tc.wantErr || exitCode != tc.code {
+			t.Fatalf("%s: err=%v exit=%d, want exit %d", tc.mode, err, exitCode, tc.code)
+		}
+	}
+}
+
+func Test_ProcessCandidates_ListErrorIsNoSuccess(t *testing.T) {
+	listErr := errors.New("list pods: connection reset")
+	src := func(ctx context.Context, emit func(engine.Candidate) error) error {
+		if err := emit(engine.Candidate{Kind: "pod", Namespace: "default", Name: "a"}); err != nil {
+			return err
+		}
+		return listErr
+	}
+	act := func(ctx context.Context, c engine.Candidate) cleanupRecord {
+		return newRecord(c, time.Now())
+	}
+	m := metrics.New()
+	dryRun, concurrency, auditFile, output = true, 1, "", "text"
+	if err := processCandidates(context.Background(), src, act, m, time.Now()); !errors.Is(err, listErr) {
+		t.Fatalf("err = %v, want the list error", err)
+	}
+
+	mfs, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "k8s_cleanup_last_success_timestamp_seconds" && mf.GetMetric()[0].GetGauge().GetValue() != 0 {
+			t.Fatal("a run whose listing failed must not set the last success timestamp")
+		}
+	}
+}
+
+func Test_SetDeleteResult_LogArchiveOnlyForPodsAndJobs(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "test"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	l, err := backup.OpenPodLogs(fake.NewSimpleClientset(pod), t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podLogs = l
+	defer func() { podLogs = nil }()
+	if err := l.Save(context.Background(), "pod", pod); err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, want := range map[string]string{"pod": l.Location("test", "shared"), "configmap": "", "job": ""} {
+		rec := newRecord(engine.Candidate{Kind: kind, Namespace: "test", Name: "shared"}, time.Now())
+		setDeleteResult(&rec, engine.DeleteResult{}, nil)
+		if rec.LogArchive != want {
+			t.Errorf("%s: logArchive = %q, want %q", kind, rec.LogArchive, want)
+		}
+	}
+}
+
+func Test_ObserveRecord_CountsEveryDeleteCall(t *testing.T) {
+	m := metrics.New()
+	now := time.Now()
+	rec := func(name string, res engine.DeleteResult, err error) cleanupRecord {
+		r := newRecord(engine.Candidate{Kind: "pod", Namespace: "test", Name: name, State: "Succeeded"}, now)
+		r.DryRun = false
+		setDeleteResult(&r, res, err)
+		return r
+	}
+	observeRecord(m, rec("retried", engine.DeleteResult{Retries: 2, Calls: 3}, nil))
+	observeRecord(m, rec("conflict", engine.DeleteResult{Calls: 1}, engine.ErrChanged))
+	// The backup found it changed before any delete call was sent.
+	observeRecord(m, rec("backup", engine.DeleteResult{}, engine.ErrChanged))
+
+	mfs, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, mf := range mfs {
+		for _, metric := range mf.GetMetric() {
+			got[mf.GetName()] += metric.GetCounter().GetValue()
+		}
+	}
+	for name, want := range map[string]float64{
+		"k8s_cleanup_deletions_attempted_total": 4,
+		"k8s_cleanup_deletions_succeeded_total": 1,
+		"k8s_cleanup_deletions_failed_total":    3,
+	} {
+		if got[name] != want {
+			t.Errorf("%s = %v, want %v", name, got[name], want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/snapshot"
+	"github.com/spf13/cobra"
+)
+
+var snapshotPath string
+
+// simulation is the JSON output of simulate.
+type simulation struct {
+	Now    time.Time      `json:"now"`
+	Listed map[string]int `json:"listed,omitempty"`
+	Items  []planItem     `json:"items"`
+}
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Evaluate a policy against saved manifests instead of a cluster",
+	Long:  "Loads a directory of YAML/JSON manifests, or a kubectl get -o yaml List dump, into fake clients and prints what run would delete, as of --now. Takes the same filter flags as run and needs no cluster access, so policies can be tested against golden files in CI.",
+	Example: `  kubectl get pods,jobs -A -o yaml > snapshot.yaml
+  k8s-cleanup simulate --snapshot snapshot.yaml --all-namespaces --policy policy.yaml --now 2024-05-07T00:00:00Z`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bindFlags(cmd.Flags())
+		applyDefaults()
+		syncFromViper()
+		return runSimulate(cmd.Context(), cmd.OutOrStdout())
+	},
+}
+
+func runSimulate(ctx context.Context, w io.Writer) error {
+	if snapshotPath == "" {
+		return fmt.Errorf("--snapshot is required")
+	}
+	now, err := parseNowFlag()
+	if err != nil {
+		return err
+	}
+	if now.IsZero() {
+		now = time.Now()
+	}
+	snap, err := snapshot.Load(snapshotPath)
+	if err != nil {
+		return err
+	}
+	kube, dyn, mapper, err := snap.Clients()
+	if err != nil {
+		return err
+	}
+	ecfg, err := engineConfig()
+	if err != nil {
+		return err
+	}
+	eng := engine.New(kube, ecfg, engine.WithDynamic(dyn, mapper), engine.WithNow(now))
+	cands, err := eng.FindCandidates(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	if strings.EqualFold(output, "json") {
+		sim := simulation{Now: now.UTC(), Listed: eng.Listed(), Items: newPlan(ecfg, cands, nil, now).Items}
+		data, err := json.MarshalIndent(sim, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	for _, c := range cands {
+		obj := c.Kind + "/" + c.Name
+		if c.Namespace != "" {
+			obj = c.Namespace + "/" + obj
+		}
+		rule := ""
+		if c.Rule != "" {
+			rule = ", rule " + c.Rule
+		}
+		fmt.Fprintf(w, "would delete %s (state %s, age %s%s)\n", obj, c.State, c.Age.Round(time.Second), rule)
+	}
+	fmt.Fprintf(w, "%d candidates as of %s\n", len(cands), now.UTC().Format(time.RFC3339))
+	return nil
+}
+
+func init() {
+	fs := simulateCmd.Flags()
+	addFilterFlags(fs)
+	fs.StringVar(&snapshotPath, "snapshot", "", "Manifest file or directory of .yaml/.yml/.json files to evaluate")
+	addNowFlag(fs)
+	fs.StringVar(&output, "output", "text", "Output format: text|json")
+	rootCmd.AddCommand(simulateCmd)
+}
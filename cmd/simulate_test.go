@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Simulate_Golden(t *testing.T) {
+	snapshotPath, nowAt, output = "testdata/simulate/snapshot.yaml", "2024-05-07T00:00:00Z", "text"
+	allNS, olderThan = true, "36h"
+	// simulate deletes nothing, so --now needs no --dry-run there.
+	dryRun = false
+	defer func() { snapshotPath, nowAt, allNS, olderThan, dryRun = "", "", false, "24h", true }()
+
+	var buf bytes.Buffer
+	if err := runSimulate(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/simulate/expected.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Fatalf("simulate output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func Test_ParseNow_RequiresDryRun(t *testing.T) {
+	defer func() { nowAt, dryRun = "", true }()
+	nowAt, dryRun = "2024-05-07T00:00:00Z", false
+	if _, err := parseNow(); err == nil {
+		t.Fatal("--now without --dry-run must fail")
+	}
+	dryRun = true
+	if now, err := parseNow(); err != nil || now.Format(time.RFC3339) != nowAt {
+		t.Fatalf("parseNow = %s, %v", now, err)
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/metrics"
+)
+
+// SkipScheduled is the skip reason for marked candidates whose notice period
+// has not ended yet.
+const SkipScheduled = "scheduled"
+
+var noticePeriod time.Duration
+
+// runMarkSweep replaces deleting with two phases. Due candidates without a
+// mark are marked for deletion --notice-period from now; marked ones are
+// deleted once the mark has expired and they still match. Marked objects that
+// are no longer due, e.g. because their owner added the protect label, get
+// the mark removed. Deletion limits apply to the expired marks.
+func runMarkSweep(ctx context.Context, eng *engine.Engine, m *metrics.Metrics) error {
+	start, now := time.Now(), eng.Now()
+	var expired, rest []engine.Candidate
+	stale := map[string]bool{}
+	err := eng.StreamDecisions(ctx, func(d engine.Decision) error {
+		marked := !d.ScheduledAt.IsZero()
+		switch {
+		case d.Selected && !d.DueAt.After(now):
+			if marked && !d.ScheduledAt.After(now) {
+				expired = append(expired, d.Candidate)
+			} else {
+				rest = append(rest, d.Candidate)
+			}
+		case marked:
+			stale[candidateKey(d.Candidate)] = true
+			rest = append(rest, d.Candidate)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if limitsEnabled() {
+		var ok bool
+		if expired, ok, err = enforceLimits(expired, eng.Listed()); !ok {
+			return err
+		}
+	}
+
+	del := deleteAction(eng, false)
+	act := func(ctx context.Context, c engine.Candidate) cleanupRecord {
+		switch {
+		case stale[candidateKey(c)]:
+			rec := newRecord(c, eng.Now())
+			rec.Mark = "removed"
+			if !dryRun {
+				setMarkResult(&rec, eng.Unmark(ctx, c))
+			}
+			return rec
+		case c.ScheduledAt.IsZero():
+			at := now.Add(noticePeriod).UTC().Truncate(time.Second)
+			rec := newRecord(c, eng.Now())
+			rec.Mark, rec.ScheduledDeletion = "added", &at
+			if !dryRun {
+				setMarkResult(&rec, eng.Mark(ctx, c, at))
+				if rec.Error == "" && rec.Skipped == "" {
+					emitMarkEvent(ctx, c, at)
+				}
+			}
+			return rec
+		case c.ScheduledAt.After(now):
+			rec := newRecord(c, eng.Now())
+			at := c.ScheduledAt
+			rec.Skipped, rec.ScheduledDeletion = SkipScheduled, &at
+			return rec
+		default:
+			rec := del(ctx, c)
+			at := c.ScheduledAt
+			rec.ScheduledDeletion = &at
+			return rec
+		}
+	}
+	return processCandidates(ctx, sliceSource(append(expired, rest...)), act, m, start)
+}
+
+func setMarkResult(r *cleanupRecord, err error) {
+	switch {
+	case errors.Is(err, engine.ErrChanged):
+		r.Skipped = engine.SkipChanged
+	case err != nil:
+		r.Error = err.Error()
+	}
+}
+
+func candidateKey(c engine.Candidate) string {
+	return c.Kind + "/" + c.Namespace + "/" + c.Name
+}
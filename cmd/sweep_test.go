@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_MarkSweep(t *testing.T) {
+	now := time.Now()
+	pod := func(name string, age time.Duration, mark time.Time, labels map[string]string) *corev1.Pod {
+		started := metav1.NewTime(now.Add(-age))
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: labels},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded, StartTime: &started},
+		}
+		if !mark.IsZero() {
+			p.Annotations = map[string]string{engine.ScheduledDeletionAnnotation: mark.UTC().Format(time.RFC3339)}
+		}
+		return p
+	}
+	kube := fake.NewSimpleClientset(
+		pod("new-candidate", 2*time.Hour, time.Time{}, nil),
+		pod("expired", 2*time.Hour, now.Add(-time.Minute), nil),
+		pod("waiting", 2*time.Hour, now.Add(time.Hour), nil),
+		pod("protected", 2*time.Hour, now.Add(-time.Minute), map[string]string{"keep": "true"}),
+		pod("young", time.Minute, now.Add(-time.Minute), nil),
+	)
+	eng := engine.New(kube, engine.Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"default"}, IncludeCompleted: true, ProtectKey: "keep", ProtectVal: "true"})
+
+	dryRun, concurrency, auditFile, output, noticePeriod = false, 2, "", "text", 24*time.Hour
+	defer func() { dryRun, exitCode, noticePeriod = true, 0, 0 }()
+	if err := runMarkSweep(context.Background(), eng, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pods := kube.CoreV1().Pods("default")
+	get := func(name string) *corev1.Pod {
+		p, err := pods.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		return p
+	}
+	if p := get("expired"); p != nil {
+		t.Error("expired mark should have been deleted")
+	}
+	mark := get("new-candidate").Annotations[engine.ScheduledDeletionAnnotation]
+	if at, err := time.Parse(time.RFC3339, mark); err != nil || at.Sub(now) < 23*time.Hour {
+		t.Errorf("new candidate mark %q, want about 24h ahead", mark)
+	}
+	if get("waiting").Annotations[engine.ScheduledDeletionAnnotation] == "" {
+		t.Error("waiting mark must be left alone")
+	}
+	for _, name := range []string{"protected", "young"} {
+		p := get(name)
+		if p == nil {
+			t.Fatalf("%s deleted", name)
+		}
+		if _, ok := p.Annotations[engine.ScheduledDeletionAnnotation]; ok {
+			t.Errorf("%s no longer matches, its mark should be removed", name)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/spf13/pflag"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	deleteQPS          float64
+	deleteBurst        int
+	deleteRetries      int
+	deleteRetryBackoff time.Duration
+	kubeAPIQPS         float32
+	kubeAPIBurst       int
+)
+
+func addDeleteRateFlags(fs *pflag.FlagSet) {
+	fs.Float64Var(&deleteQPS, "delete-qps", 0, "Maximum delete calls per second across all workers (0 for no limit)")
+	fs.IntVar(&deleteBurst, "delete-burst", 1, "Delete calls allowed in a burst above --delete-qps")
+	fs.IntVar(&deleteRetries, "delete-retries", 5, "Retry deletes that failed with 429, 5xx or timeouts this many times")
+	fs.DurationVar(&deleteRetryBackoff, "delete-retry-backoff", time.Second, "Initial delay between delete retries; doubles per retry up to 1m")
+}
+
+func addClientFlags(fs *pflag.FlagSet) {
+	fs.Float32Var(&kubeAPIQPS, "kube-api-qps", 0, "Client-side QPS limit for all API calls (0 uses the client-go default of 5)")
+	fs.IntVar(&kubeAPIBurst, "kube-api-burst", 0, "Client-side burst for all API calls (0 uses the client-go default of 10)")
+}
+
+func deleteRateOptions() []engine.Option {
+	opts := []engine.Option{engine.WithDeleteRetry(wait.Backoff{
+		Duration: deleteRetryBackoff,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    deleteRetries,
+		Cap:      time.Minute,
+	})}
+	if deleteQPS > 0 {
+		burst := deleteBurst
+		if burst < 1 {
+			burst = 1
+		}
+		opts = append(opts, engine.WithDeleteRateLimit(rate.NewLimiter(rate.Limit(deleteQPS), burst)))
+	}
+	return opts
+}
+
+func applyClientLimits(cfg *rest.Config) {
+	if kubeAPIQPS > 0 {
+		cfg.QPS = kubeAPIQPS
+	}
+	if kubeAPIBurst > 0 {
+		cfg.Burst = kubeAPIBurst
+	}
+}
@@ -0,0 +1,319 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+const clusterScope = "_cluster"
+
+// Entry is one backed-up object together with the engine kind it was deleted as.
+type Entry struct {
+	Kind   string
+	Object *unstructured.Unstructured
+}
+
+// Sink stores manifests of objects about to be deleted. It satisfies
+// engine.Backup.
+type Sink interface {
+	Save(ctx context.Context, kind string, obj runtime.Object) error
+	Close() error
+}
+
+// ErrTruncated is returned by Read, together with the complete entries, for an
+// archive that was never closed, e.g. because the run crashed.
+var ErrTruncated = errors.New("archive is truncated")
+
+// IsArchive reports whether path names a tar.gz archive rather than a directory.
+func IsArchive(p string) bool {
+	return strings.HasSuffix(p, ".tar.gz") || strings.HasSuffix(p, ".tgz")
+}
+
+// Open returns a sink writing to a tar.gz archive or a directory, depending on
+// the path's extension. A "{timestamp}" in the path is replaced with the
+// current UTC time so scheduled runs do not collide; an existing archive is
+// never overwritten.
+func Open(p string) (Sink, error) {
+	fw, err := openFiles(p)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestSink{files: fw}, nil
+}
+
+type manifestSink struct {
+	files fileWriter
+}
+
+func (s *manifestSink) Save(_ context.Context, kind string, obj runtime.Object) error {
+	name, data, err := encode(kind, obj)
+	if err != nil {
+		return err
+	}
+	return s.files.WriteFile(name, data)
+}
+
+func (s *manifestSink) Close() error {
+	return s.files.Close()
+}
+
+// Manifest converts obj to a clean manifest that can be created again: server
+// populated fields are removed and apiVersion/kind are filled in.
+func Manifest(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if obj.GetObjectKind().GroupVersionKind().Empty() {
+		gvks, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			return nil, err
+		}
+		obj = obj.DeepCopyObject()
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: m}
+	Strip(u)
+	return u, nil
+}
+
+// Strip removes status and server-managed metadata. Owner references are
+// removed too: they name owners by UID, so a restored object would be garbage
+// collected right away once its owner is gone. For Jobs it also drops the
+// generated selector and controller-uid labels, which the API server rejects
+// on create.
+func Strip(u *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(u.Object, "status")
+	for _, f := range []string{"managedFields", "resourceVersion", "uid", "creationTimestamp",
+		"generation", "selfLink", "deletionTimestamp", "deletionGracePeriodSeconds", "ownerReferences"} {
+		unstructured.RemoveNestedField(u.Object, "metadata", f)
+	}
+	if u.GetKind() == "Job" && u.GroupVersionKind().Group == "batch" {
+		unstructured.RemoveNestedField(u.Object, "spec", "selector")
+		for _, field := range [][]string{{"metadata", "labels"}, {"spec", "template", "metadata", "labels"}} {
+			for _, l := range []string{"controller-uid", "batch.kubernetes.io/controller-uid"} {
+				unstructured.RemoveNestedField(u.Object, append(field, l)...)
+			}
+		}
+	}
+}
+
+func entryName(kind string, u *unstructured.Unstructured) string {
+	ns := u.GetNamespace()
+	if ns == "" {
+		ns = clusterScope
+	}
+	return path.Join(ns, kind, u.GetName()+".yaml")
+}
+
+func encode(kind string, obj runtime.Object) (string, []byte, error) {
+	u, err := Manifest(obj)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := yaml.Marshal(u.Object)
+	if err != nil {
+		return "", nil, err
+	}
+	return entryName(kind, u), data, nil
+}
+
+// fileWriter stores named files in a directory or a tar.gz archive.
+type fileWriter interface {
+	WriteFile(name string, data []byte) error
+	// Location is where name ends up, for reporting.
+	Location(name string) string
+	Close() error
+}
+
+func openFiles(p string) (fileWriter, error) {
+	p = strings.ReplaceAll(p, "{timestamp}", time.Now().UTC().Format("20060102T150405Z"))
+	if IsArchive(p) {
+		return newTarFiles(p)
+	}
+	if err := os.MkdirAll(p, 0o700); err != nil {
+		return nil, err
+	}
+	return &dirFiles{dir: p}, nil
+}
+
+type dirFiles struct {
+	dir string
+}
+
+func (d *dirFiles) WriteFile(name string, data []byte) error {
+	file := d.Location(name)
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0o600)
+}
+
+func (d *dirFiles) Location(name string) string {
+	return filepath.Join(d.dir, filepath.FromSlash(name))
+}
+
+func (d *dirFiles) Close() error {
+	return nil
+}
+
+type tarFiles struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func newTarFiles(p string) (*tarFiles, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &tarFiles{path: p, f: f, gz: gz, tw: tar.NewWriter(gz)}, nil
+}
+
+func (t *tarFiles) WriteFile(name string, data []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := t.tw.Write(data); err != nil {
+		return err
+	}
+	if err := t.tw.Flush(); err != nil {
+		return err
+	}
+	// Flush the compressor too, so every saved entry is readable even if the
+	// archive is never closed.
+	return t.gz.Flush()
+}
+
+func (t *tarFiles) Location(name string) string {
+	return t.path + "#" + name
+}
+
+func (t *tarFiles) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if err := t.gz.Close(); err != nil {
+		return err
+	}
+	return t.f.Close()
+}
+
+// Read loads every manifest from a backup directory or tar.gz archive, in
+// path order. For an archive that ends early it returns the complete entries
+// and ErrTruncated.
+func Read(p string) ([]Entry, error) {
+	if IsArchive(p) {
+		return readTar(p)
+	}
+	var out []Entry
+	err := filepath.WalkDir(p, func(file string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(file, ".yaml") {
+			return err
+		}
+		rel, err := filepath.Rel(p, file)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		e, err := decode(filepath.ToSlash(rel), data)
+		if err != nil {
+			return err
+		}
+		out = append(out, e)
+		return nil
+	})
+	return out, err
+}
+
+func readTar(p string) ([]Entry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var out []Entry
+	var truncated error
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			truncated = fmt.Errorf("%s: %w", p, ErrTruncated)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			truncated = fmt.Errorf("%s: %w", p, ErrTruncated)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(hdr.Name, data)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return entryName(out[i].Kind, out[i].Object) < entryName(out[j].Kind, out[j].Object)
+	})
+	return out, truncated
+}
+
+func decode(name string, data []byte) (Entry, error) {
+	u := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u.Object); err != nil {
+		return Entry{}, fmt.Errorf("%s: %w", name, err)
+	}
+	kind := u.GetKind()
+	if parts := strings.Split(name, "/"); len(parts) == 3 {
+		kind = parts[1]
+	}
+	return Entry{Kind: kind, Object: u}, nil
+}
@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testJob() *batchv1.Job {
+	labels := map[string]string{"app": "x", "batch.kubernetes.io/controller-uid": "abc", "controller-uid": "abc"}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "j", Namespace: "test", UID: "abc", ResourceVersion: "42",
+			Labels:          labels,
+			ManagedFields:   []metav1.ManagedFieldsEntry{{Manager: "kube"}},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: "nightly", UID: "def"}},
+		},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"batch.kubernetes.io/controller-uid": "abc"}},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+		Status: batchv1.JobStatus{Succeeded: 1},
+	}
+}
+
+func Test_Manifest_StripsServerFields(t *testing.T) {
+	u, err := Manifest(testJob())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.GetAPIVersion() != "batch/v1" || u.GetKind() != "Job" {
+		t.Fatalf("gvk not set: %s %s", u.GetAPIVersion(), u.GetKind())
+	}
+	if u.GetUID() != "" || u.GetResourceVersion() != "" || len(u.GetManagedFields()) != 0 || len(u.GetOwnerReferences()) != 0 {
+		t.Fatalf("metadata not stripped: %v", u.Object["metadata"])
+	}
+	if _, ok := u.Object["status"]; ok {
+		t.Fatal("status not stripped")
+	}
+	if _, ok, _ := unstructured.NestedMap(u.Object, "spec", "selector"); ok {
+		t.Fatal("job selector not stripped")
+	}
+	labels, _, _ := unstructured.NestedStringMap(u.Object, "spec", "template", "metadata", "labels")
+	if len(labels) != 1 || labels["app"] != "x" {
+		t.Fatalf("template labels = %v", labels)
+	}
+}
+
+func Test_Sinks_RoundTrip(t *testing.T) {
+	for test, name := range map[string]string{"dir": "dir", "archive": "backup-{timestamp}.tar.gz"} {
+		t.Run(test, func(t *testing.T) {
+			dir := t.TempDir()
+			sink, err := Open(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "test"}, Data: map[string]string{"k": "v"}}
+			pv := &corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}}
+			if err := sink.Save(context.Background(), "configmap", cm); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Save(context.Background(), "pv", pv); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Save(context.Background(), "job", testJob()); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			from := filepath.Join(dir, name)
+			if IsArchive(name) {
+				matches, _ := filepath.Glob(filepath.Join(dir, "backup-*.tar.gz"))
+				if len(matches) != 1 {
+					t.Fatalf("archive not created: %v", matches)
+				}
+				from = matches[0]
+			}
+			entries, err := Read(from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("want 3 entries, got %d", len(entries))
+			}
+			got := map[string]Entry{}
+			for _, e := range entries {
+				got[e.Kind] = e
+			}
+			if got["pv"].Object.GetName() != "pv-1" || got["pv"].Object.GetNamespace() != "" {
+				t.Fatalf("pv entry = %+v", got["pv"])
+			}
+			if v, _, _ := unstructured.NestedString(got["configmap"].Object.Object, "data", "k"); v != "v" {
+				t.Fatalf("configmap data lost: %v", got["configmap"].Object.Object)
+			}
+			if got["job"].Object.GetKind() != "Job" {
+				t.Fatalf("job entry = %+v", got["job"])
+			}
+		})
+	}
+}
+
+func Test_Open_DoesNotOverwriteArchive(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "b.tar.gz")
+	sink, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sink.Close()
+	if _, err := Open(file); err == nil {
+		t.Fatal("expected error opening an existing archive")
+	}
+}
+
+func Test_Read_UnclosedArchive(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "b.tar.gz")
+	sink, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for _, name := range []string{"a", "b"} {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"}}
+		if err := sink.Save(context.Background(), "configmap", cm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The run dies here: the archive is never closed.
+	entries, err := Read(file)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("want ErrTruncated, got %v", err)
+	}
+	if len(entries) != 2 || entries[0].Object.GetName() != "a" || entries[1].Object.GetName() != "b" {
+		t.Fatalf("entries = %+v", entries)
+	}
+}
+
+func openTar(t *testing.T, file string) (*tar.Reader, func()) {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tar.NewReader(gz), func() {
+		_ = gz.Close()
+		_ = f.Close()
+	}
+}
@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodLogs archives the logs of every container of a Pod right before it is
+// deleted, as <namespace>/<pod>/<container>.log plus <container>.previous.log
+// for restarted containers. Deleting a Job deletes its pods too, so their logs
+// are archived under <namespace>/<job>/<pod>/. It satisfies engine.Backup and
+// ignores other kinds.
+type PodLogs struct {
+	kube     kubernetes.Interface
+	files    fileWriter
+	maxBytes int64
+
+	mu    sync.Mutex
+	saved map[string]bool
+}
+
+// OpenPodLogs writes to a directory or tar.gz archive like Open. Each log is
+// cut at maxBytes when it is positive.
+func OpenPodLogs(kube kubernetes.Interface, p string, maxBytes int64) (*PodLogs, error) {
+	fw, err := openFiles(p)
+	if err != nil {
+		return nil, err
+	}
+	return &PodLogs{kube: kube, files: fw, maxBytes: maxBytes}, nil
+}
+
+// Location is where the logs of one pod, or of the pods of one job, are
+// written.
+func (l *PodLogs) Location(ns, name string) string {
+	return l.files.Location(path.Join(ns, name))
+}
+
+// Archived reports where the logs of the named pod or job were saved, if
+// they were. kind is "pod" or "job"; other kinds never have logs.
+func (l *PodLogs) Archived(kind, ns, name string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.saved[path.Join(kind, ns, name)] {
+		return "", false
+	}
+	return l.Location(ns, name), true
+}
+
+func (l *PodLogs) Save(ctx context.Context, _ string, obj runtime.Object) error {
+	var err error
+	var kind string
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		kind, err = "pod", l.savePod(ctx, o, path.Join(o.Namespace, o.Name))
+	case *batchv1.Job:
+		kind, err = "job", l.saveJob(ctx, o)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m := obj.(metav1.Object)
+	l.mu.Lock()
+	if l.saved == nil {
+		l.saved = map[string]bool{}
+	}
+	l.saved[path.Join(kind, m.GetNamespace(), m.GetName())] = true
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *PodLogs) saveJob(ctx context.Context, job *batchv1.Job) error {
+	sel := labels.SelectorFromSet(labels.Set{"job-name": job.Name})
+	if job.Spec.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+		if err != nil {
+			return err
+		}
+		sel = s
+	}
+	pods, err := l.kube.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return fmt.Errorf("list pods of job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if err := l.savePod(ctx, pod, path.Join(job.Namespace, job.Name, pod.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savePod writes the logs of every container of pod below dir.
+func (l *PodLogs) savePod(ctx context.Context, pod *corev1.Pod, dir string) error {
+	restarts := map[string]int32{}
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		restarts[cs.Name] = cs.RestartCount
+	}
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if err := l.saveContainer(ctx, pod, dir, c.Name, false); err != nil {
+			return err
+		}
+		if restarts[c.Name] > 0 {
+			if err := l.saveContainer(ctx, pod, dir, c.Name, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveContainer skips containers without logs, e.g. ones that never started,
+// which the API reports as NotFound or BadRequest. Any other error, such as a
+// missing pods/log permission or a timeout, fails the save so the pod is not
+// deleted with its logs unread.
+func (l *PodLogs) saveContainer(ctx context.Context, pod *corev1.Pod, dir, container string, previous bool) error {
+	opts := &corev1.PodLogOptions{Container: container, Previous: previous}
+	if l.maxBytes > 0 {
+		limit := l.maxBytes + 1
+		opts.LimitBytes = &limit
+	}
+	stream, err := l.kube.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).Stream(ctx)
+	if apierrors.IsNotFound(err) || apierrors.IsBadRequest(err) {
+		log.Debug().Err(err).Str("ns", pod.Namespace).Str("pod", pod.Name).Str("container", container).Bool("previous", previous).Msg("no logs to archive")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("logs of %s/%s container %s: %w", pod.Namespace, pod.Name, container, err)
+	}
+	defer stream.Close()
+
+	var r io.Reader = stream
+	if l.maxBytes > 0 {
+		r = io.LimitReader(stream, l.maxBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read logs of %s/%s container %s: %w", pod.Namespace, pod.Name, container, err)
+	}
+	if l.maxBytes > 0 && int64(len(data)) > l.maxBytes {
+		data = append(data[:l.maxBytes], fmt.Sprintf("\n[k8s-cleanup: truncated at %d bytes]\n", l.maxBytes)...)
+	}
+
+	name := container + ".log"
+	if previous {
+		name = container + ".previous.log"
+	}
+	return l.files.WriteFile(path.Join(dir, name), data)
+}
+
+func (l *PodLogs) Close() error {
+	return l.files.Close()
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+func logPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "test"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init"}},
+			Containers:     []corev1.Container{{Name: "app"}, {Name: "sidecar"}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: 2}, {Name: "sidecar"}},
+		},
+	}
+}
+
+func Test_PodLogs_WritesEveryContainer(t *testing.T) {
+	dir := t.TempDir()
+	pod := logPod()
+	l, err := OpenPodLogs(fake.NewSimpleClientset(pod), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(context.Background(), "pod", pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"init.log", "app.log", "app.previous.log", "sidecar.log"} {
+		data, err := os.ReadFile(filepath.Join(dir, "test", "p", f))
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if len(data) == 0 {
+			t.Fatalf("%s is empty", f)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test", "p", "sidecar.previous.log")); !os.IsNotExist(err) {
+		t.Fatal("previous logs only expected for restarted containers")
+	}
+	if got := l.Location("test", "p"); got != filepath.Join(dir, "test", "p") {
+		t.Fatalf("location = %s", got)
+	}
+}
+
+func Test_PodLogs_TruncatesAndArchives(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logs.tar.gz")
+	pod := logPod()
+	l, err := OpenPodLogs(fake.NewSimpleClientset(pod), file, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(context.Background(), "pod", pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(context.Background(), "job", &corev1.ConfigMap{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Location("test", "p"); got != file+"#test/p" {
+		t.Fatalf("location = %s", got)
+	}
+
+	files := map[string]string{}
+	tr, closeFn := openTar(t, file)
+	defer closeFn()
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+	if len(files) != 4 {
+		t.Fatalf("want 4 log files, got %v", files)
+	}
+	app := files["test/p/app.log"]
+	if !strings.HasPrefix(app, "fake") || !strings.Contains(app, "truncated at 4 bytes") {
+		t.Fatalf("app.log = %q", app)
+	}
+}
+
+// logStatus answers every log request with code.
+type logStatus struct {
+	kubernetes.Interface
+	code int
+}
+
+func (k logStatus) CoreV1() corev1client.CoreV1Interface {
+	return logCore{k.Interface.CoreV1(), k.code}
+}
+
+type logCore struct {
+	corev1client.CoreV1Interface
+	code int
+}
+
+func (c logCore) Pods(ns string) corev1client.PodInterface {
+	return logPods{c.CoreV1Interface.Pods(ns), c.code}
+}
+
+type logPods struct {
+	corev1client.PodInterface
+	code int
+}
+
+func (p logPods) GetLogs(name string, opts *corev1.PodLogOptions) *restclient.Request {
+	c := &fakerest.RESTClient{
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: p.code, Body: io.NopCloser(strings.NewReader(http.StatusText(p.code)))}, nil
+		}),
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		GroupVersion:         corev1.SchemeGroupVersion,
+	}
+	return c.Request()
+}
+
+func Test_PodLogs_FailsOnUnreadableLogs(t *testing.T) {
+	pod := logPod()
+	for _, tc := range []struct {
+		code    int
+		wantErr bool
+	}{
+		{http.StatusForbidden, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+		{http.StatusNotFound, false},
+	} {
+		l, err := OpenPodLogs(logStatus{fake.NewSimpleClientset(pod), tc.code}, t.TempDir(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.Save(context.Background(), "pod", pod)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%d: err = %v, want error %t", tc.code, err, tc.wantErr)
+		}
+		if _, ok := l.Archived("pod", "test", "p"); ok == tc.wantErr {
+			t.Fatalf("%d: archived = %t", tc.code, ok)
+		}
+	}
+}
+
+func Test_PodLogs_ArchivesJobPods(t *testing.T) {
+	dir := t.TempDir()
+	pod := logPod()
+	pod.Labels = map[string]string{"job-name": "j"}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "test"}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "j", Namespace: "test"}}
+	l, err := OpenPodLogs(fake.NewSimpleClientset(pod, other, job), dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(context.Background(), "job", job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test", "j", "p", "app.log")); err != nil {
+		t.Fatalf("job pod logs not archived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "test", "j", "other")); !os.IsNotExist(err) {
+		t.Fatal("pods of other jobs must not be archived")
+	}
+	if loc, ok := l.Archived("job", "test", "j"); !ok || loc != filepath.Join(dir, "test", "j") {
+		t.Fatalf("archived = %q, %t", loc, ok)
+	}
+}
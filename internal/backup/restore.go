@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"context"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// Filter selects entries to restore. Empty fields match everything.
+type Filter struct {
+	Namespace string
+	Name      string
+	Kind      string
+}
+
+func (f Filter) Match(e Entry) bool {
+	if f.Namespace != "" && e.Object.GetNamespace() != f.Namespace {
+		return false
+	}
+	if f.Name != "" && e.Object.GetName() != f.Name {
+		return false
+	}
+	if f.Kind != "" {
+		k := helpers.NormalizeKind(f.Kind)
+		if k != helpers.NormalizeKind(e.Kind) && k != helpers.NormalizeKind(e.Object.GetKind()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Restore re-creates one backed-up object through the dynamic client.
+func Restore(ctx context.Context, dyn dynamic.Interface, mapper meta.RESTMapper, u *unstructured.Unstructured) error {
+	gvk := u.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+	obj := u.DeepCopy()
+	obj.SetResourceVersion("")
+	var ri dynamic.ResourceInterface = dyn.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		ri = dyn.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+	}
+	_, err = ri.Create(ctx, obj, metav1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+)
+
+func Test_Filter_Match(t *testing.T) {
+	u, err := Manifest(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Entry{Kind: "configmap", Object: u}
+	for _, tc := range []struct {
+		f    Filter
+		want bool
+	}{
+		{Filter{}, true},
+		{Filter{Namespace: "test", Name: "cm"}, true},
+		{Filter{Kind: "ConfigMaps"}, true},
+		{Filter{Namespace: "other"}, false},
+		{Filter{Name: "x"}, false},
+		{Filter{Kind: "job"}, false},
+	} {
+		if got := tc.f.Match(e); got != tc.want {
+			t.Fatalf("%+v: got %v, want %v", tc.f, got, tc.want)
+		}
+	}
+}
+
+func Test_Restore_CreatesObject(t *testing.T) {
+	gvr := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	dyn := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "ConfigMapList"})
+
+	u, err := Manifest(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "test", ResourceVersion: "7"},
+		Data:       map[string]string{"k": "v"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(context.Background(), dyn, mapper, u); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	got, err := dyn.Resource(gvr).Namespace("test").Get(context.Background(), "cm", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get restored: %v", err)
+	}
+	if got.Object["data"].(map[string]interface{})["k"] != "v" {
+		t.Fatalf("restored data = %v", got.Object["data"])
+	}
+	if err := Restore(context.Background(), dyn, mapper, u); err == nil {
+		t.Fatal("expected AlreadyExists on second restore")
+	}
+}
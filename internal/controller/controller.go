@@ -0,0 +1,352 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Options tune the controller. Zero values fall back to the defaults below.
+type Options struct {
+	Resync     time.Duration
+	Delay      time.Duration
+	RetryAfter time.Duration
+	DryRun     bool
+	// Report is called after each deletion attempt, or each would-be deletion
+	// in dry-run mode.
+	Report func(c engine.Candidate, res engine.DeleteResult, err error)
+}
+
+const (
+	defaultResync     = 10 * time.Minute
+	defaultDelay      = 5 * time.Second
+	defaultRetryAfter = time.Minute
+)
+
+// Controller watches Pods and Jobs and deletes each one shortly after it
+// becomes due, instead of waiting for the next periodic scan. Due times are
+// measured against the engine's clock.
+type Controller struct {
+	eng  *engine.Engine
+	opts Options
+
+	factory   informers.SharedInformerFactory
+	informers map[string]cache.SharedIndexInformer
+
+	mu       sync.Mutex
+	queue    *expiryQueue
+	reported map[string]bool
+	wake     chan struct{}
+}
+
+func New(eng *engine.Engine, opts Options) (*Controller, error) {
+	if opts.Resync <= 0 {
+		opts.Resync = defaultResync
+	}
+	if opts.Delay <= 0 {
+		opts.Delay = defaultDelay
+	}
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = defaultRetryAfter
+	}
+	cfg := eng.Config()
+	fopts := []informers.SharedInformerOption{
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = cfg.LabelSelector
+			o.FieldSelector = cfg.FieldSelector
+		}),
+	}
+	if !cfg.AllNamespaces && len(cfg.Namespaces) == 1 {
+		fopts = append(fopts, informers.WithNamespace(cfg.Namespaces[0]))
+	}
+	c := &Controller{
+		eng:       eng,
+		opts:      opts,
+		factory:   informers.NewSharedInformerFactoryWithOptions(eng.Kube(), opts.Resync, fopts...),
+		informers: map[string]cache.SharedIndexInformer{},
+		queue:     newExpiryQueue(),
+		reported:  map[string]bool{},
+		wake:      make(chan struct{}, 1),
+	}
+	for _, name := range watchedKinds(cfg) {
+		var inf cache.SharedIndexInformer
+		switch name {
+		case "pod":
+			inf = c.factory.Core().V1().Pods().Informer()
+		case "job":
+			inf = c.factory.Batch().V1().Jobs().Informer()
+		default:
+			return nil, fmt.Errorf("controller mode does not support kind %q (supported: pod, job)", name)
+		}
+		kind := name
+		_, err := inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { c.observe(kind, obj) },
+			UpdateFunc: func(_, obj interface{}) { c.observe(kind, obj) },
+			DeleteFunc: func(obj interface{}) { c.forget(kind, obj) },
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.informers[kind] = inf
+	}
+	return c, nil
+}
+
+// watchedKinds returns the normalized kinds named by the config and its rules.
+func watchedKinds(cfg engine.Config) []string {
+	names := append([]string(nil), cfg.Kinds...)
+	for _, r := range cfg.Rules {
+		names = append(names, r.Kinds...)
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, n := range names {
+		n = helpers.NormalizeKind(n)
+		if n == "" || seen[n] {
+			continue
+		}
+		seen[n] = true
+		out = append(out, n)
+	}
+	return out
+}
+
+// Run starts the informers and deletes due objects until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	c.factory.Start(ctx.Done())
+	for kind, ok := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("cache for %v did not sync", kind)
+		}
+	}
+	log.Info().Int("kinds", len(c.informers)).Msg("controller caches synced")
+	defer c.factory.Shutdown()
+
+	for {
+		c.mu.Lock()
+		_, due, ok := c.queue.Peek()
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if ok {
+			timer = time.NewTimer(due.Sub(c.eng.Now()))
+			fire = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.wake:
+		case <-fire:
+			c.process(ctx)
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// process evaluates every key that has come due. Keys are queued for
+// evaluation as the informers see changes, and again for deletion once the
+// object is due, so Evaluate and its API lookups run here rather than on the
+// informer's handler goroutine.
+func (c *Controller) process(ctx context.Context) {
+	c.mu.Lock()
+	keys := c.queue.PopDue(c.eng.Now())
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		kind, ns, name := splitKey(key)
+		inf := c.informers[kind]
+		if inf == nil {
+			continue
+		}
+		obj, exists, err := inf.GetIndexer().GetByKey(cacheKey(ns, name))
+		if err != nil || !exists {
+			continue
+		}
+		o, ok := obj.(metav1.Object)
+		if !ok || c.deleting(kind, o) {
+			continue
+		}
+		ds, err := c.eng.Evaluate(ctx, kind, c.group(kind, o))
+		if err != nil {
+			log.Error().Err(err).Str("kind", kind).Str("ns", ns).Str("name", name).Msg("evaluate failed")
+			c.schedule(key, c.eng.Now().Add(c.opts.RetryAfter))
+			continue
+		}
+		// With KeepLast the namespace siblings were decided too, since
+		// retention depends on the whole group.
+		for _, d := range ds {
+			if d.Name == name {
+				c.act(ctx, key, d)
+			} else {
+				c.track(queueKey(kind, d.Namespace, d.Name), d)
+			}
+		}
+	}
+}
+
+// act deletes the object behind key if d says it is due, and schedules it
+// for later otherwise.
+func (c *Controller) act(ctx context.Context, key string, d engine.Decision) {
+	if !d.Selected {
+		return
+	}
+	if due := d.DueAt.Add(c.opts.Delay); due.After(c.eng.Now()) {
+		c.schedule(key, due)
+		return
+	}
+	if c.opts.DryRun {
+		c.mu.Lock()
+		c.reported[key] = true
+		c.mu.Unlock()
+		c.report(d.Candidate, engine.DeleteResult{}, nil)
+		return
+	}
+	res, err := c.eng.DeleteDetailed(ctx, d.Candidate)
+	c.report(d.Candidate, res, err)
+	// A changed object, or a terminating pod whose status changes, comes
+	// back through the informer and is decided again.
+	if err != nil && !errors.Is(err, engine.ErrChanged) && !errors.Is(err, engine.ErrStillTerminating) {
+		c.schedule(key, c.eng.Now().Add(c.opts.RetryAfter))
+	}
+}
+
+// track schedules or drops a sibling decided alongside another object.
+func (c *Controller) track(key string, d engine.Decision) {
+	if !d.Selected {
+		c.unschedule(key)
+		return
+	}
+	c.mu.Lock()
+	done := c.reported[key]
+	c.mu.Unlock()
+	if !done {
+		c.schedule(key, d.DueAt.Add(c.opts.Delay))
+	}
+}
+
+// group is o and, with KeepLast, its namespace siblings that are not being
+// deleted already.
+func (c *Controller) group(kind string, o metav1.Object) []metav1.Object {
+	if c.eng.Config().KeepLast <= 0 {
+		return []metav1.Object{o}
+	}
+	objs, err := c.informers[kind].GetIndexer().ByIndex(cache.NamespaceIndex, o.GetNamespace())
+	if err != nil {
+		return []metav1.Object{o}
+	}
+	out := make([]metav1.Object, 0, len(objs))
+	for _, x := range objs {
+		if m, ok := x.(metav1.Object); ok && !c.deleting(kind, m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// deleting reports whether o already has a deletionTimestamp and is left
+// alone. Pods stuck Terminating are the exception while TerminatingAfter is
+// set, since that handling owns them.
+func (c *Controller) deleting(kind string, o metav1.Object) bool {
+	if o.GetDeletionTimestamp() == nil {
+		return false
+	}
+	return kind != "pod" || c.eng.Config().TerminatingAfter <= 0
+}
+
+// observe queues a changed object for evaluation by process.
+func (c *Controller) observe(kind string, obj interface{}) {
+	o, ok := obj.(metav1.Object)
+	if !ok || !c.eng.InScope(o.GetNamespace()) {
+		return
+	}
+	key := queueKey(kind, o.GetNamespace(), o.GetName())
+	if c.deleting(kind, o) {
+		c.unschedule(key)
+		return
+	}
+	c.mu.Lock()
+	done := c.reported[key]
+	c.mu.Unlock()
+	if !done {
+		c.schedule(key, c.eng.Now())
+	}
+}
+
+func (c *Controller) forget(kind string, obj interface{}) {
+	if t, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = t.Obj
+	}
+	o, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	key := queueKey(kind, o.GetNamespace(), o.GetName())
+	c.unschedule(key)
+	c.mu.Lock()
+	delete(c.reported, key)
+	c.mu.Unlock()
+	if c.eng.Config().KeepLast > 0 {
+		if rest := c.group(kind, o); len(rest) > 0 {
+			c.observe(kind, rest[0])
+		}
+	}
+}
+
+func (c *Controller) schedule(key string, due time.Time) {
+	c.mu.Lock()
+	c.queue.Schedule(key, due)
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *Controller) unschedule(key string) {
+	c.mu.Lock()
+	c.queue.Remove(key)
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *Controller) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Controller) report(cand engine.Candidate, res engine.DeleteResult, err error) {
+	if c.opts.Report != nil {
+		c.opts.Report(cand, res, err)
+	}
+}
+
+func queueKey(kind, ns, name string) string {
+	return kind + "/" + ns + "/" + name
+}
+
+func splitKey(key string) (kind, ns, name string) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 {
+		return "", "", ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+func cacheKey(ns, name string) string {
+	if ns == "" {
+		return name
+	}
+	return ns + "/" + name
+}
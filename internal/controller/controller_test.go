@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func pod(name string, phase corev1.PodPhase, started time.Time) *corev1.Pod {
+	t := metav1.NewTime(started)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Status:     corev1.PodStatus{Phase: phase, StartTime: &t},
+	}
+}
+
+func Test_Controller_DeletesWhenDue(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+		pod("old", corev1.PodSucceeded, time.Now().Add(-2*time.Hour)),
+		pod("soon", corev1.PodSucceeded, time.Now().Add(-time.Hour+300*time.Millisecond)),
+		pod("running", corev1.PodRunning, time.Now().Add(-2*time.Hour)),
+	)
+	eng := engine.New(kube, engine.Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	})
+
+	var mu sync.Mutex
+	reported := map[string]error{}
+	ctrl, err := New(eng, Options{
+		Delay: time.Millisecond,
+		Report: func(c engine.Candidate, _ engine.DeleteResult, err error) {
+			mu.Lock()
+			reported[c.Name] = err
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- ctrl.Run(ctx) }()
+
+	for {
+		mu.Lock()
+		n := len(reported)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timed out, reported %v", reported)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	for _, name := range []string{"old", "soon"} {
+		if err, ok := reported[name]; !ok || err != nil {
+			t.Fatalf("%s: reported=%v err=%v", name, ok, err)
+		}
+		if _, err := kube.CoreV1().Pods("test").Get(context.Background(), name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Fatalf("%s should be deleted, got %v", name, err)
+		}
+	}
+	if _, err := kube.CoreV1().Pods("test").Get(context.Background(), "running", metav1.GetOptions{}); err != nil {
+		t.Fatalf("running pod should remain: %v", err)
+	}
+}
+
+func Test_Controller_UsesEngineClock(t *testing.T) {
+	kube := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+		pod("young", corev1.PodSucceeded, time.Now().Add(-30*time.Minute)),
+	)
+	eng := engine.New(kube, engine.Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	}, engine.WithNow(time.Now().Add(2*time.Hour)))
+
+	reported := make(chan string, 1)
+	ctrl, err := New(eng, Options{
+		Delay:  time.Millisecond,
+		DryRun: true,
+		Report: func(c engine.Candidate, _ engine.DeleteResult, _ error) { reported <- c.Name },
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() { _ = ctrl.Run(ctx) }()
+	select {
+	case name := <-reported:
+		if name != "young" {
+			t.Fatalf("reported %s", name)
+		}
+	case <-ctx.Done():
+		t.Fatal("pod due by the engine clock was not processed")
+	}
+}
+
+func Test_New_RejectsUnsupportedKinds(t *testing.T) {
+	eng := engine.New(fake.NewSimpleClientset(), engine.Config{Kinds: []string{"pod", "configmap"}})
+	if _, err := New(eng, Options{}); err == nil {
+		t.Fatal("expected error for configmap kind")
+	}
+}
+
+func Test_Observe_QueuesWithoutCallingTheAPI(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	eng := engine.New(kube, engine.Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	})
+	ctrl, err := New(eng, Options{})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	ctrl.observe("pod", pod("old", corev1.PodSucceeded, time.Now().Add(-2*time.Hour)))
+	if n := len(kube.Actions()); n != 0 {
+		t.Fatalf("the informer handler made %d API calls", n)
+	}
+	if key, due, ok := ctrl.queue.Peek(); !ok || key != "pod/test/old" || due.After(eng.Now()) {
+		t.Fatalf("queued %q at %v, %t", key, due, ok)
+	}
+}
+
+func Test_Observe_SkipsObjectsBeingDeleted(t *testing.T) {
+	deleting := func(name string) *corev1.Pod {
+		p := pod(name, corev1.PodSucceeded, time.Now().Add(-2*time.Hour))
+		ts := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		p.DeletionTimestamp = &ts
+		return p
+	}
+	for _, tc := range []struct {
+		terminatingAfter time.Duration
+		wantQueued       bool
+	}{
+		{0, false},
+		{time.Hour, true},
+	} {
+		eng := engine.New(fake.NewSimpleClientset(), engine.Config{
+			OlderThan:        time.Hour,
+			Kinds:            []string{"pod"},
+			Namespaces:       []string{"test"},
+			IncludeCompleted: true,
+			TerminatingAfter: tc.terminatingAfter,
+		})
+		ctrl, err := New(eng, Options{})
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		ctrl.schedule("pod/test/p", time.Now().Add(time.Hour))
+		ctrl.observe("pod", deleting("p"))
+		if queued := ctrl.queue.Len() == 1; queued != tc.wantQueued {
+			t.Fatalf("terminatingAfter %v: queued = %t", tc.terminatingAfter, queued)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"container/heap"
+	"time"
+)
+
+type entry struct {
+	key   string
+	due   time.Time
+	index int
+}
+
+type entries []*entry
+
+func (h entries) Len() int           { return len(h) }
+func (h entries) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h entries) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entries) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entries) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// expiryQueue orders object keys by the time they become due. Scheduling an
+// existing key moves it. It is not safe for concurrent use.
+type expiryQueue struct {
+	heap  entries
+	byKey map[string]*entry
+}
+
+func newExpiryQueue() *expiryQueue {
+	return &expiryQueue{byKey: map[string]*entry{}}
+}
+
+func (q *expiryQueue) Len() int {
+	return len(q.heap)
+}
+
+func (q *expiryQueue) Schedule(key string, due time.Time) {
+	if e, ok := q.byKey[key]; ok {
+		e.due = due
+		heap.Fix(&q.heap, e.index)
+		return
+	}
+	e := &entry{key: key, due: due}
+	heap.Push(&q.heap, e)
+	q.byKey[key] = e
+}
+
+func (q *expiryQueue) Remove(key string) {
+	e, ok := q.byKey[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&q.heap, e.index)
+	delete(q.byKey, key)
+}
+
+func (q *expiryQueue) Peek() (string, time.Time, bool) {
+	if len(q.heap) == 0 {
+		return "", time.Time{}, false
+	}
+	return q.heap[0].key, q.heap[0].due, true
+}
+
+// PopDue removes and returns every key due at or before now, earliest first.
+func (q *expiryQueue) PopDue(now time.Time) []string {
+	var out []string
+	for len(q.heap) > 0 && !q.heap[0].due.After(now) {
+		e := heap.Pop(&q.heap).(*entry)
+		delete(q.byKey, e.key)
+		out = append(out, e.key)
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_ExpiryQueue_OrdersByDue(t *testing.T) {
+	q := newExpiryQueue()
+	base := time.Now()
+	q.Schedule("c", base.Add(3*time.Second))
+	q.Schedule("a", base.Add(1*time.Second))
+	q.Schedule("b", base.Add(2*time.Second))
+
+	if key, _, ok := q.Peek(); !ok || key != "a" {
+		t.Fatalf("peek = %q, want a", key)
+	}
+	got := q.PopDue(base.Add(2 * time.Second))
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("popDue = %v", got)
+	}
+	if q.Len() != 1 {
+		t.Fatalf("len = %d, want 1", q.Len())
+	}
+}
+
+func Test_ExpiryQueue_RescheduleAndRemove(t *testing.T) {
+	q := newExpiryQueue()
+	base := time.Now()
+	q.Schedule("a", base.Add(time.Second))
+	q.Schedule("b", base.Add(2*time.Second))
+	q.Schedule("a", base.Add(3*time.Second))
+
+	if key, _, _ := q.Peek(); key != "b" {
+		t.Fatalf("peek after reschedule = %q, want b", key)
+	}
+	if q.Len() != 2 {
+		t.Fatalf("len = %d, want 2", q.Len())
+	}
+	q.Remove("b")
+	q.Remove("missing")
+	got := q.PopDue(base.Add(time.Hour))
+	if !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("popDue = %v", got)
+	}
+	if _, _, ok := q.Peek(); ok {
+		t.Fatal("queue should be empty")
+	}
+}
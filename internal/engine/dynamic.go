@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type StateFunc func(u *unstructured.Unstructured) string
+
+type RefTimeFunc func(u *unstructured.Unstructured) time.Time
+
+var (
+	argoWorkflows      = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows"}
+	tektonPipelineRuns = schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "pipelineruns"}
+	tektonTaskRuns     = schema.GroupVersionResource{Group: "tekton.dev", Version: "v1", Resource: "taskruns"}
+)
+
+// DynamicKind builds a Kind served by the dynamic client. When the engine has
+// a REST mapper the preferred version is discovered at list time and
+// gvr.Version is only a fallback.
+func DynamicKind(name string, gvr schema.GroupVersionResource, state StateFunc, ref RefTimeFunc) Kind {
+	if state == nil {
+		state = GenericState
+	}
+	if ref == nil {
+		ref = GenericRefTime
+	}
+	page := func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error) {
+		res, err := e.dynamicResource(gvr)
+		if err != nil {
+			return nil, "", err
+		}
+		list, err := e.dyn.Resource(res).Namespace(ns).List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		out := make([]Item, 0, len(list.Items))
+		for i := range list.Items {
+			u := &list.Items[i]
+			out = append(out, Item{Object: u, State: state(u), RefTime: ref(u)})
+		}
+		return out, list.GetContinue(), nil
+	}
+	return Kind{
+		Name:     name,
+		List:     pagedList(page),
+		ListPage: page,
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			res, err := e.dynamicResource(gvr)
+			if err != nil {
+				return nil, err
+			}
+			return e.dyn.Resource(res).Namespace(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			res, err := e.dynamicResource(gvr)
+			if err != nil {
+				return err
+			}
+			return e.dyn.Resource(res).Namespace(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			res, err := e.dynamicResource(gvr)
+			if err != nil {
+				return err
+			}
+			_, err = e.dyn.Resource(res).Namespace(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+	}
+}
+
+func (e *Engine) dynamicResource(gvr schema.GroupVersionResource) (schema.GroupVersionResource, error) {
+	if e.dyn == nil {
+		return gvr, fmt.Errorf("resource %s requires a dynamic client", gvr.GroupResource())
+	}
+	if e.mapper == nil {
+		return gvr, nil
+	}
+	res, err := e.mapper.ResourceFor(gvr.GroupResource().WithVersion(""))
+	if err != nil {
+		if gvr.Version != "" {
+			return gvr, nil
+		}
+		return gvr, err
+	}
+	return res, nil
+}
+
+func (e *Engine) discoverKind(name string) (Kind, error) {
+	full, gr := schema.ParseResourceArg(strings.ToLower(name))
+	var res schema.GroupVersionResource
+	var err error
+	if full != nil {
+		res, err = e.mapper.ResourceFor(*full)
+	}
+	if full == nil || err != nil {
+		res, err = e.mapper.ResourceFor(gr.WithVersion(""))
+	}
+	if err != nil {
+		return Kind{}, fmt.Errorf("unknown kind %q: %w", name, err)
+	}
+	gvk, err := e.mapper.KindFor(res)
+	if err != nil {
+		return Kind{}, err
+	}
+	mapping, err := e.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return Kind{}, err
+	}
+	k := DynamicKind(res.GroupResource().String(), res, GenericState, GenericRefTime)
+	k.Aliases = []string{name}
+	k.ClusterScoped = mapping.Scope.Name() == meta.RESTScopeNameRoot
+	return k, nil
+}
+
+// GenericState understands the common status shapes: a status.phase string
+// (Argo, Pods) or a Succeeded/Complete/Failed condition (Tekton, Jobs).
+func GenericState(u *unstructured.Unstructured) string {
+	if phase, _, _ := unstructured.NestedString(u.Object, "status", "phase"); phase != "" {
+		switch strings.ToLower(phase) {
+		case "succeeded", "completed", "complete":
+			return "Succeeded"
+		case "failed", "error":
+			return "Failed"
+		default:
+			return phase
+		}
+	}
+	conds, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, c := range conds {
+		m, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		typ, _ := m["type"].(string)
+		status, _ := m["status"].(string)
+		switch {
+		case typ == "Succeeded" && status == "True", typ == "Complete" && status == "True":
+			return "Succeeded"
+		case typ == "Succeeded" && status == "False", typ == "Failed" && status == "True":
+			return "Failed"
+		}
+	}
+	return "Active"
+}
+
+// GenericRefTime prefers status.completionTime or status.finishedAt and falls
+// back to the creation timestamp.
+func GenericRefTime(u *unstructured.Unstructured) time.Time {
+	for _, field := range []string{"completionTime", "finishedAt"} {
+		s, _, _ := unstructured.NestedString(u.Object, "status", field)
+		if s == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t
+		}
+	}
+	return u.GetCreationTimestamp().Time
+}
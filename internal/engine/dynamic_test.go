@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func unstructuredObj(gvk schema.GroupVersionKind, ns, name string, status map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+	u.SetGroupVersionKind(gvk)
+	u.SetNamespace(ns)
+	u.SetName(name)
+	return u
+}
+
+func Test_FindCandidates_ArgoWorkflows(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Workflow"}
+	old := time.Now().Add(-3 * time.Hour).UTC().Format(time.RFC3339)
+	recent := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	dyn := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{argoWorkflows: "WorkflowList"},
+		unstructuredObj(gvk, "test", "wf-ok", map[string]interface{}{"phase": "Succeeded", "finishedAt": old}),
+		unstructuredObj(gvk, "test", "wf-err", map[string]interface{}{"phase": "Error", "finishedAt": old}),
+		unstructuredObj(gvk, "test", "wf-running", map[string]interface{}{"phase": "Running"}),
+		unstructuredObj(gvk, "test", "wf-new", map[string]interface{}{"phase": "Succeeded", "finishedAt": recent}),
+	)
+	e := New(fake.NewSimpleClientset(ns("test")), Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"workflows.argoproj.io"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+		IncludeFailed:    true,
+	}, WithDynamic(dyn, nil))
+	list, err := e.FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("want 2 candidates, got %+v", list)
+	}
+	for _, c := range list {
+		if err := e.Delete(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_FindCandidates_DiscoveredKind(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	gvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{gvk.GroupVersion()})
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+
+	old := time.Now().Add(-3 * time.Hour).UTC().Format(time.RFC3339)
+	dyn := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gvr: "WidgetList"},
+		unstructuredObj(gvk, "test", "w1", map[string]interface{}{
+			"completionTime": old,
+			"conditions":     []interface{}{map[string]interface{}{"type": "Succeeded", "status": "False"}},
+		}),
+	)
+	e := New(fake.NewSimpleClientset(ns("test")), Config{
+		OlderThan:     time.Hour,
+		Kinds:         []string{"widgets.example.com"},
+		Namespaces:    []string{"test"},
+		IncludeFailed: true,
+	}, WithDynamic(dyn, mapper))
+	list, err := e.FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Kind != "widgets.example.com" || list[0].State != "Failed" {
+		t.Fatalf("unexpected candidates: %+v", list)
+	}
+	if err := e.Delete(context.Background(), list[0]); err != nil {
+		t.Fatal(err)
+	}
+}
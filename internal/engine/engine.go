@@ -2,11 +2,22 @@ package engine
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	"golang.org/x/time/rate"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 )
 
 type Config struct {
@@ -22,115 +33,702 @@ type Config struct {
 	IncludeEvicted    bool
 	ProtectKey        string
 	ProtectVal        string
+	KeepRevisions     int
+	PVCIdle           time.Duration
+	DeleteRetainedPVs bool
+	KeepLast          int
+	KeepLastLabel     string
+	Rules             []Rule
+	// PageSize is the number of objects fetched per list call for kinds that
+	// support paging; 0 means 500.
+	PageSize int64
+	// TerminatingAfter selects pods stuck Terminating for this long, aged from
+	// their deletionTimestamp; 0 leaves them alone. Deleting such a pod strips
+	// StripFinalizers and, with ForceDeleteTerminating, force deletes it when
+	// its node is NotReady or gone.
+	TerminatingAfter       time.Duration
+	ForceDeleteTerminating bool
+	StripFinalizers        []string
 }
 
 type Candidate struct {
-	Kind      string
-	Namespace string
-	Name      string
-	State     string
-	Age       time.Duration
+	Kind            string
+	Namespace       string
+	Name            string
+	UID             types.UID
+	ResourceVersion string
+	State           string
+	Age             time.Duration
+	Rule            string
+	TTLSource       string
+	// Owner is the object's controller, if any.
+	Owner *metav1.OwnerReference
+	// ScheduledAt is the object's ScheduledDeletionAnnotation, if marked.
+	ScheduledAt time.Time
 }
 
 type Engine struct {
-	kube kubernetes.Interface
-	cfg  Config
+	kube    kubernetes.Interface
+	dyn     dynamic.Interface
+	mapper  meta.RESTMapper
+	kinds   *Registry
+	cfg     Config
+	obs     Observer
+	backups []Backup
+	limiter *rate.Limiter
+	meta    metadata.Interface
+	retry   wait.Backoff
+	clock   Clock
+
+	mu        sync.Mutex
+	refs      map[string]*refGraph
+	ownerAnn  map[string]cachedOwners
+	ownerGets map[string]cachedAnnotations
+	nsAnn     map[string]cachedAnnotations
+	listed    map[string]int
+}
+
+type Option func(*Engine)
+
+// WithDynamic enables kinds served by the dynamic client. The mapper is used
+// to resolve --kind values that are not registered, e.g. "workflows.argoproj.io".
+func WithDynamic(dyn dynamic.Interface, mapper meta.RESTMapper) Option {
+	return func(e *Engine) {
+		e.dyn = dyn
+		e.mapper = mapper
+	}
+}
+
+// Observer is told how long each List and Delete call made by the engine took.
+type Observer interface {
+	ObserveList(kind, namespace string, d time.Duration, err error)
+	ObserveDelete(kind, namespace string, d time.Duration, err error)
 }
 
-func New(kube kubernetes.Interface, cfg Config) *Engine {
-	return &Engine{kube: kube, cfg: cfg}
+func WithObserver(o Observer) Option {
+	return func(e *Engine) {
+		e.obs = o
+	}
+}
+
+// Backup receives a fresh copy of every object right before it is deleted. A
+// failing Save aborts that deletion.
+type Backup interface {
+	Save(ctx context.Context, kind string, obj runtime.Object) error
 }
 
+// WithBackup adds a backup; several may be configured and all run in order.
+func WithBackup(b Backup) Option {
+	return func(e *Engine) {
+		e.backups = append(e.backups, b)
+	}
+}
+
+// WithDeleteRateLimit makes every delete call, retries included, and every
+// Mark and Unmark wait for a token from l.
+func WithDeleteRateLimit(l *rate.Limiter) Option {
+	return func(e *Engine) {
+		e.limiter = l
+	}
+}
+
+// WithDeleteRetry retries deletes that failed with throttling or server errors
+// up to b.Steps times, sleeping per b or the server's Retry-After if longer.
+func WithDeleteRetry(b wait.Backoff) Option {
+	return func(e *Engine) {
+		e.retry = b
+	}
+}
+
+// Clock tells the engine the time that ages and due times are measured
+// against. Durations of API calls are always measured in real time.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// FixedClock is a Clock that always returns the same instant.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time { return time.Time(c) }
+
+// WithClock replaces the real time the engine measures ages against.
+func WithClock(c Clock) Option {
+	return func(e *Engine) {
+		e.clock = c
+	}
+}
+
+// WithNow evaluates ages as of t instead of the current time, e.g. to
+// simulate a policy against a snapshot taken earlier.
+func WithNow(t time.Time) Option {
+	return WithClock(FixedClock(t))
+}
+
+func WithRegistry(r *Registry) Option {
+	return func(e *Engine) {
+		e.kinds = r
+	}
+}
+
+func New(kube kubernetes.Interface, cfg Config, opts ...Option) *Engine {
+	e := &Engine{kube: kube, cfg: cfg, clock: realClock{}}
+	for _, o := range opts {
+		o(e)
+	}
+	if e.kinds == nil {
+		e.kinds = DefaultRegistry()
+	}
+	return e
+}
+
+// Now is the engine's clock reading.
+func (e *Engine) Now() time.Time {
+	return e.clock.Now()
+}
+
+func (e *Engine) Kube() kubernetes.Interface {
+	return e.kube
+}
+
+func (e *Engine) Dynamic() dynamic.Interface {
+	return e.dyn
+}
+
+// FindCandidates returns every object that is due for deletion.
 func (e *Engine) FindCandidates(ctx context.Context) ([]Candidate, error) {
-	namespaces, err := e.resolveNamespaces(ctx)
+	var out []Candidate
+	err := e.StreamCandidates(ctx, func(c Candidate) error {
+		out = append(out, c)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	cutoff := time.Now().Add(-e.cfg.OlderThan)
-	var out []Candidate
+	return out, nil
+}
 
-	if helpers.HasKind(e.cfg.Kinds, "pod") {
-		for _, ns := range namespaces {
-			list, err := e.kube.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{
-				LabelSelector: e.cfg.LabelSelector,
-				FieldSelector: e.cfg.FieldSelector,
-			})
+// StreamCandidates is FindCandidates handing each candidate to fn as soon as
+// the page it was listed in has been decided, so deletions can start before
+// listing ends. An error from fn stops the listing and is returned.
+func (e *Engine) StreamCandidates(ctx context.Context, fn func(Candidate) error) error {
+	now := e.Now()
+	return e.streamDecisions(ctx, now, func(d Decision) error {
+		if d.Selected && !d.DueAt.After(now) {
+			return fn(d.Candidate)
+		}
+		return nil
+	})
+}
+
+// StreamDecisions is StreamCandidates handing fn the decision for every listed
+// object, due or not. With WithMetadata, objects that cannot be due are left
+// out unless they carry ScheduledDeletionAnnotation.
+func (e *Engine) StreamDecisions(ctx context.Context, fn func(Decision) error) error {
+	return e.streamDecisions(ctx, e.Now(), fn)
+}
+
+func (e *Engine) streamDecisions(ctx context.Context, now time.Time, fn func(Decision) error) error {
+	rules, err := e.compileRules()
+	if err != nil {
+		return err
+	}
+	kinds, err := e.kindsFor(rules)
+	if err != nil {
+		return err
+	}
+	namespaces, err := e.resolveNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	e.resetCaches()
+	listed := map[string]int{}
+	defer func() {
+		e.mu.Lock()
+		e.listed = listed
+		e.mu.Unlock()
+	}()
+
+	for _, k := range kinds {
+		err := e.listKind(ctx, rules, k, namespaces, now, func(items []Item) error {
+			listed[k.Name] += len(items)
+			if e.usesMetadata(k) {
+				items = fullItems(items)
+			}
+			ds, err := e.decide(ctx, rules, k, items, now)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			for i := range list.Items {
-				p := list.Items[i]
-				if e.protected(p.Labels) {
-					continue
-				}
-				state := helpers.PodState(&p)
-				if !e.stateIncluded(state) {
-					continue
+			for _, d := range ds {
+				if err := fn(d); err != nil {
+					return err
 				}
-				ts := p.CreationTimestamp.Time
-				if p.Status.StartTime != nil {
-					ts = p.Status.StartTime.Time
-				}
-				if ts.After(cutoff) {
-					continue
-				}
-				out = append(out, Candidate{
-					Kind:      "pod",
-					Namespace: ns,
-					Name:      p.Name,
-					State:     state,
-					Age:       time.Since(ts),
-				})
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if helpers.HasKind(e.cfg.Kinds, "job") {
-		for _, ns := range namespaces {
-			list, err := e.kube.BatchV1().Jobs(ns).List(ctx, metav1.ListOptions{
-				LabelSelector: e.cfg.LabelSelector,
-				FieldSelector: e.cfg.FieldSelector,
-			})
+// listKind passes the in-scope objects of k to fn in batches. Kinds with
+// ListPage are listed Config.PageSize at a time, in a single cluster-wide list
+// filtered to namespaces when Config.AllNamespaces is set. KeepLast needs every
+// object of a namespace at once, so it falls back to one batch per namespace.
+func (e *Engine) listKind(ctx context.Context, rules []compiledRule, k Kind, namespaces []string, now time.Time, fn func([]Item) error) error {
+	opts := metav1.ListOptions{
+		LabelSelector: e.cfg.LabelSelector,
+		FieldSelector: e.cfg.FieldSelector,
+	}
+	scopes := namespaces
+	if k.ClusterScoped {
+		scopes = []string{metav1.NamespaceNone}
+	}
+	page := k.ListPage
+	if e.usesMetadata(k) {
+		page = e.metadataPage(k, rules, now)
+	}
+	list := func(ns string, opts metav1.ListOptions) ([]Item, string, error) {
+		start := time.Now()
+		items, cont, err := page(ctx, e, ns, opts)
+		if e.obs != nil {
+			e.obs.ObserveList(k.Name, ns, time.Since(start), err)
+		}
+		return items, cont, err
+	}
+	// paged follows continue tokens while fn handles each page. Slow deletes
+	// can outlast a token, so an expired one restarts the list and objects
+	// already handed to fn are dropped from the pages that follow.
+	seen := map[string]bool{}
+	paged := func(ns string, fn func([]Item) error) error {
+		opts := opts
+		opts.Limit = e.pageSize()
+		for {
+			items, cont, err := list(ns, opts)
+			if err != nil && opts.Continue != "" && apierrors.IsResourceExpired(err) {
+				opts.Continue = ""
+				continue
+			}
 			if err != nil {
-				return nil, err
+				return err
 			}
-			for i := range list.Items {
-				j := list.Items[i]
-				if e.protected(j.Labels) {
-					continue
-				}
-				state := helpers.JobState(&j)
-				if !e.stateIncluded(state) {
-					continue
+			fresh := items[:0]
+			for _, it := range items {
+				key := it.Object.GetNamespace() + "/" + it.Object.GetName() + "/" + string(it.Object.GetUID())
+				if !seen[key] {
+					seen[key] = true
+					fresh = append(fresh, it)
 				}
-				ref := helpers.JobRefTime(&j)
-				if ref.After(cutoff) {
-					continue
+			}
+			if err := fn(fresh); err != nil {
+				return err
+			}
+			if cont == "" {
+				return nil
+			}
+			opts.Continue = cont
+		}
+	}
+
+	switch {
+	case k.ListPage == nil || e.cfg.KeepLast > 0:
+		if k.ListPage != nil {
+			opts.Limit = e.pageSize()
+		}
+		for _, ns := range scopes {
+			start := time.Now()
+			var items []Item
+			var err error
+			if k.ListPage != nil {
+				items, err = pagedList(k.ListPage)(ctx, e, ns, opts)
+			} else {
+				items, err = k.List(ctx, e, ns, opts)
+			}
+			if e.obs != nil {
+				e.obs.ObserveList(k.Name, ns, time.Since(start), err)
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(items); err != nil {
+				return err
+			}
+		}
+		return nil
+	case e.cfg.AllNamespaces && !k.ClusterScoped:
+		inScope := make(map[string]bool, len(namespaces))
+		for _, ns := range namespaces {
+			inScope[ns] = true
+		}
+		return paged(metav1.NamespaceAll, func(items []Item) error {
+			kept := items[:0]
+			for _, it := range items {
+				if inScope[it.Object.GetNamespace()] {
+					kept = append(kept, it)
 				}
-				out = append(out, Candidate{
-					Kind:      "job",
-					Namespace: ns,
-					Name:      j.Name,
-					State:     state,
-					Age:       time.Since(ref),
-				})
+			}
+			return fn(kept)
+		})
+	default:
+		for _, ns := range scopes {
+			if err := paged(ns, fn); err != nil {
+				return err
 			}
 		}
+		return nil
+	}
+}
+
+const defaultPageSize = 500
+
+func (e *Engine) pageSize() int64 {
+	if e.cfg.PageSize > 0 {
+		return e.cfg.PageSize
+	}
+	return defaultPageSize
+}
+
+// Listed returns how many objects of each kind the last FindCandidates listed,
+// after label and field selectors.
+func (e *Engine) Listed() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make(map[string]int, len(e.listed))
+	for k, n := range e.listed {
+		out[k] = n
+	}
+	return out
+}
+
+// Decision is the outcome of evaluating one object. Selected objects pass every
+// filter but age; they become candidates once DueAt has passed.
+type Decision struct {
+	Candidate
+	Selected bool
+	DueAt    time.Time
+}
+
+// Evaluate classifies objects of one kind from a single namespace the way
+// FindCandidates would. The whole set is needed to honour Config.KeepLast.
+// Owner and namespace annotations are cached across calls for up to
+// annotationCacheTTL.
+func (e *Engine) Evaluate(ctx context.Context, kind string, objs []metav1.Object) ([]Decision, error) {
+	k, err := e.resolveKind(kind)
+	if err != nil {
+		return nil, err
+	}
+	if k.Classify == nil {
+		return nil, fmt.Errorf("kind %q cannot be evaluated per object", k.Name)
 	}
+	rules, err := e.compileRules()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, 0, len(objs))
+	for _, o := range objs {
+		items = append(items, k.Classify(o))
+	}
+	return e.decide(ctx, rules, k, items, e.Now())
+}
 
+func (e *Engine) decide(ctx context.Context, rules []compiledRule, k Kind, items []Item, now time.Time) ([]Decision, error) {
+	kept := e.keepLast(items)
+	out := make([]Decision, 0, len(items))
+	for i, it := range items {
+		d := Decision{Candidate: Candidate{
+			Kind:            k.Name,
+			Namespace:       it.Object.GetNamespace(),
+			Name:            it.Object.GetName(),
+			UID:             it.Object.GetUID(),
+			ResourceVersion: it.Object.GetResourceVersion(),
+			State:           it.State,
+			Age:             now.Sub(it.RefTime),
+			Owner:           metav1.GetControllerOf(it.Object),
+			ScheduledAt:     scheduledAt(it.Object),
+		}}
+		out = append(out, d)
+		v, err := e.judge(ctx, rules, k, it, kept[i])
+		if err != nil {
+			return nil, err
+		}
+		if !v.selected {
+			continue
+		}
+		out[len(out)-1].Selected = true
+		out[len(out)-1].DueAt = v.ttl.due(it.RefTime)
+		out[len(out)-1].Rule = v.rule.Name
+		out[len(out)-1].TTLSource = v.ttl.source
+	}
 	return out, nil
 }
 
+// verdict is how judge decided one item. Checks run in field order and stop
+// at the first that rules the item out; later fields stay zero.
+type verdict struct {
+	kept      bool
+	rule      *compiledRule
+	protected bool
+	stateOK   bool
+	ttl       ttl
+	selected  bool
+}
+
+// judge applies the per-item checks of decide. Explain reports the same
+// verdict so the two cannot disagree.
+func (e *Engine) judge(ctx context.Context, rules []compiledRule, k Kind, it Item, kept bool) (verdict, error) {
+	v := verdict{kept: kept}
+	if kept {
+		return v, nil
+	}
+	if v.rule = matchRule(rules, k, it); v.rule == nil {
+		return v, nil
+	}
+	if v.protected = protected(v.rule.ProtectKey, v.rule.ProtectVal, it.Object.GetLabels()); v.protected {
+		return v, nil
+	}
+	if v.stateOK = e.ruleStateIncluded(v.rule, k, it.State); !v.stateOK {
+		return v, nil
+	}
+	t, err := e.resolveTTL(ctx, v.rule, it)
+	if err != nil {
+		return v, err
+	}
+	v.ttl, v.selected = t, true
+	return v, nil
+}
+
+// InScope reports whether objects in ns are covered by the namespace settings.
+func (e *Engine) InScope(ns string) bool {
+	if e.cfg.AllNamespaces {
+		for _, ex := range e.cfg.ExcludeNamespaces {
+			if ex == ns {
+				return false
+			}
+		}
+		return true
+	}
+	if len(e.cfg.Namespaces) == 0 {
+		return ns == "default"
+	}
+	for _, n := range e.cfg.Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) Config() Config {
+	return e.cfg
+}
+
+// ErrChanged is returned by Delete when the object was re-created or modified
+// after it was listed, so the candidate no longer describes it.
+var ErrChanged = errors.New("object changed since it was listed")
+
+// Delete removes the object c was listed from. Its UID and resourceVersion, when
+// known, are sent as preconditions so a re-created or modified object is never
+// deleted in its place.
 func (e *Engine) Delete(ctx context.Context, c Candidate) error {
+	_, err := e.DeleteDetailed(ctx, c)
+	return err
+}
+
+// DeleteResult describes how a candidate was deleted.
+type DeleteResult struct {
+	// Retries is how many times a delete call was retried under the
+	// WithDeleteRetry backoff.
+	Retries int
+	// Calls is how many delete calls reached the API, retries included. It
+	// is zero when the delete stopped before one was sent, e.g. because the
+	// backup found the object changed.
+	Calls int
+	// Actions lists what was done to a Terminating pod instead of a plain
+	// delete, e.g. "strip-finalizers:example.com/x" or "force-delete:node NotReady".
+	Actions []string
+}
+
+// DeleteDetailed is Delete that also reports retries and, for Terminating pods,
+// the actions taken.
+func (e *Engine) DeleteDetailed(ctx context.Context, c Candidate) (DeleteResult, error) {
+	k, err := e.resolveKind(c.Kind)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	if len(e.backups) > 0 {
+		if err := e.saveBackup(ctx, k, c); err != nil {
+			return DeleteResult{}, err
+		}
+	}
+	if c.State == StateTerminating {
+		return e.unstick(ctx, k, c)
+	}
 	pp := metav1.DeletePropagationForeground
-	switch c.Kind {
-	case "pod":
-		return e.kube.CoreV1().Pods(c.Namespace).Delete(ctx, c.Name, metav1.DeleteOptions{PropagationPolicy: &pp})
-	case "job":
-		return e.kube.BatchV1().Jobs(c.Namespace).Delete(ctx, c.Name, metav1.DeleteOptions{PropagationPolicy: &pp})
-	default:
+	calls, err := e.deleteWithRetry(ctx, k, c, metav1.DeleteOptions{PropagationPolicy: &pp, Preconditions: preconditions(c)})
+	return DeleteResult{Retries: max(calls-1, 0), Calls: calls}, err
+}
+
+// deleteWithRetry deletes c, retrying under the WithDeleteRetry backoff, and
+// returns how many delete calls it sent.
+func (e *Engine) deleteWithRetry(ctx context.Context, k Kind, c Candidate, opts metav1.DeleteOptions) (calls int, err error) {
+	backoff := e.retry
+	for {
+		if e.limiter != nil {
+			if err := e.limiter.Wait(ctx); err != nil {
+				return calls, err
+			}
+		}
+		calls++
+		start := time.Now()
+		err := k.Delete(ctx, e, c.Namespace, c.Name, opts)
+		if e.obs != nil {
+			e.obs.ObserveDelete(k.Name, c.Namespace, time.Since(start), err)
+		}
+		if err == nil || !retriable(err, opts.Preconditions != nil) || backoff.Steps < 1 {
+			if apierrors.IsConflict(err) && opts.Preconditions != nil {
+				return calls, fmt.Errorf("%w: %v", ErrChanged, err)
+			}
+			return calls, err
+		}
+		d := backoff.Step()
+		if s, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(s)*time.Second > d {
+			d = time.Duration(s) * time.Second
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return calls, err
+		case <-t.C:
+		}
+	}
+}
+
+// retriable reports whether a failed delete may succeed when repeated:
+// throttling, server errors and timeouts. Conflicts are only retried without
+// preconditions; with them they mean the object changed.
+func retriable(err error, preconditions bool) bool {
+	switch {
+	case apierrors.IsTooManyRequests(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return true
+	case apierrors.IsConflict(err):
+		return !preconditions
+	}
+	return false
+}
+
+func preconditions(c Candidate) *metav1.Preconditions {
+	if c.UID == "" && c.ResourceVersion == "" {
 		return nil
 	}
+	p := &metav1.Preconditions{}
+	if c.UID != "" {
+		uid := c.UID
+		p.UID = &uid
+	}
+	if c.ResourceVersion != "" {
+		rv := c.ResourceVersion
+		p.ResourceVersion = &rv
+	}
+	return p
+}
+
+// Skip reasons returned by Recheck.
+const (
+	SkipGone       = "gone"
+	SkipUIDChanged = "uid changed"
+	SkipChanged    = "changed"
+	SkipNoMatch    = "no longer matches"
+)
+
+// Recheck verifies that a candidate found earlier, e.g. from a saved plan, is
+// still the same object and still due. It returns a skip reason, or "" when the
+// candidate may be deleted. Objects modified since are evaluated again when the
+// kind supports it, including the label and field selectors the list applied
+// on the server; the returned candidate then carries the current
+// resourceVersion so Delete's preconditions match.
+func (e *Engine) Recheck(ctx context.Context, c Candidate) (Candidate, string, error) {
+	k, err := e.resolveKind(c.Kind)
+	if err != nil {
+		return c, "", err
+	}
+	if k.Get == nil {
+		return c, "", fmt.Errorf("kind %q cannot be rechecked", k.Name)
+	}
+	obj, err := k.Get(ctx, e, c.Namespace, c.Name)
+	if apierrors.IsNotFound(err) {
+		return c, SkipGone, nil
+	}
+	if err != nil {
+		return c, "", err
+	}
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return c, "", err
+	}
+	if c.UID != "" && m.GetUID() != c.UID {
+		return c, SkipUIDChanged, nil
+	}
+	if c.ResourceVersion == "" || m.GetResourceVersion() == c.ResourceVersion {
+		return c, "", nil
+	}
+	if k.Classify == nil || e.cfg.KeepLast > 0 {
+		return c, SkipChanged, nil
+	}
+	ds, err := e.Evaluate(ctx, k.Name, []metav1.Object{m})
+	if err != nil {
+		return c, "", err
+	}
+	if len(ds) != 1 || !ds[0].Selected || ds[0].DueAt.After(e.Now()) {
+		return c, SkipNoMatch, nil
+	}
+	if !k.ClusterScoped && !e.InScope(m.GetNamespace()) {
+		return c, SkipNoMatch, nil
+	}
+	labelOK, fieldOK, err := e.selected(k.Classify(m))
+	if err != nil {
+		return c, "", err
+	}
+	if !labelOK || !fieldOK {
+		return c, SkipNoMatch, nil
+	}
+	c.ResourceVersion = m.GetResourceVersion()
+	return c, "", nil
+}
+
+func (e *Engine) saveBackup(ctx context.Context, k Kind, c Candidate) error {
+	if k.Get == nil {
+		return fmt.Errorf("kind %q cannot be backed up", k.Name)
+	}
+	obj, err := k.Get(ctx, e, c.Namespace, c.Name)
+	if err != nil {
+		return err
+	}
+	if m, err := meta.Accessor(obj); err == nil && c.UID != "" && m.GetUID() != c.UID {
+		return ErrChanged
+	}
+	for _, b := range e.backups {
+		if err := b.Save(ctx, k.Name, obj); err != nil {
+			return fmt.Errorf("backup %s/%s: %w", c.Namespace, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// resetCaches drops per-run lookups so every FindCandidates sees fresh state.
+func (e *Engine) resetCaches() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.refs = nil
+	e.ownerAnn = nil
+	e.ownerGets = nil
+	e.nsAnn = nil
 }
 
 func (e *Engine) resolveNamespaces(ctx context.Context) ([]string, error) {
@@ -147,8 +745,13 @@ func (e *Engine) resolveNamespaces(ctx context.Context) ([]string, error) {
 	return e.cfg.Namespaces, nil
 }
 
-func (e *Engine) stateIncluded(state string) bool {
+func (e *Engine) stateIncluded(k Kind, state string) bool {
 	s := lower(state)
+	for _, sel := range k.Selects {
+		if lower(sel) == s {
+			return true
+		}
+	}
 	switch s {
 	case "succeeded":
 		return e.cfg.IncludeCompleted
@@ -156,20 +759,31 @@ func (e *Engine) stateIncluded(state string) bool {
 		return e.cfg.IncludeFailed
 	case "evicted":
 		return e.cfg.IncludeEvicted
+	case "terminating":
+		return e.cfg.TerminatingAfter > 0
 	default:
 		return false
 	}
 }
 
-func (e *Engine) protected(labels map[string]string) bool {
-	if e.cfg.ProtectKey == "" {
+func matchRule(rules []compiledRule, k Kind, it Item) *compiledRule {
+	for i := range rules {
+		if rules[i].matches(k, it) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func protected(key, val string, labels map[string]string) bool {
+	if key == "" {
 		return false
 	}
-	if e.cfg.ProtectVal == "" {
-		_, ok := labels[e.cfg.ProtectKey]
+	if val == "" {
+		_, ok := labels[key]
 		return ok
 	}
-	return labels[e.cfg.ProtectKey] == e.cfg.ProtectVal
+	return labels[key] == val
 }
 
 func lower(s string) string {
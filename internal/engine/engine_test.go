@@ -2,13 +2,21 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
+	"golang.org/x/time/rate"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func ns(name string) *corev1.Namespace {
@@ -137,3 +145,359 @@ func Test_Delete_Pod_And_Job(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func Test_Delete_SendsPreconditions(t *testing.T) {
+	j := job("test", "hook", "Succeeded", time.Now().Add(-2*time.Hour), nil)
+	j.UID, j.ResourceVersion = "new-uid", "9"
+	c := fake.NewSimpleClientset(ns("test"), j)
+	var got *meta.Preconditions
+	c.PrependReactor("delete", "jobs", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		got = a.(k8stesting.DeleteActionImpl).DeleteOptions.Preconditions
+		if got != nil && got.UID != nil && *got.UID != j.UID {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "batch", Resource: "jobs"}, j.Name, errors.New("precondition failed"))
+		}
+		return false, nil, nil
+	})
+	e := New(c, Config{})
+
+	err := e.Delete(context.Background(), Candidate{Kind: "job", Namespace: "test", Name: "hook", UID: "old-uid", ResourceVersion: "3"})
+	if !errors.Is(err, ErrChanged) {
+		t.Fatalf("want ErrChanged, got %v", err)
+	}
+	if got == nil || *got.UID != "old-uid" || *got.ResourceVersion != "3" {
+		t.Fatalf("preconditions not sent: %+v", got)
+	}
+	if _, err := c.BatchV1().Jobs("test").Get(context.Background(), "hook", meta.GetOptions{}); err != nil {
+		t.Fatalf("re-created job must survive: %v", err)
+	}
+	if err := e.Delete(context.Background(), Candidate{Kind: "job", Namespace: "test", Name: "hook", UID: "new-uid", ResourceVersion: "9"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Evaluate_ReportsDueTime(t *testing.T) {
+	started := time.Now().Add(-10 * time.Minute)
+	p := pod("test", "p", corev1.PodSucceeded, "", started, nil)
+	e := New(fake.NewSimpleClientset(ns("test")), Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	})
+	ds, err := e.Evaluate(context.Background(), "pod", []meta.Object{p})
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	if len(ds) != 1 || !ds[0].Selected {
+		t.Fatalf("expected selected decision, got %+v", ds)
+	}
+	if want := started.Add(time.Hour); !ds[0].DueAt.Equal(want) {
+		t.Fatalf("due = %v, want %v", ds[0].DueAt, want)
+	}
+	if _, err := e.Evaluate(context.Background(), "configmap", nil); err == nil {
+		t.Fatal("expected error for kind without Classify")
+	}
+}
+
+func Test_Evaluate_CachesAnnotationLookups(t *testing.T) {
+	p := pod("test", "p", corev1.PodSucceeded, "", time.Now().Add(-10*time.Minute), nil)
+	yes := true
+	p.OwnerReferences = []meta.OwnerReference{{Kind: "Job", Name: "j", Controller: &yes}}
+	c := fake.NewSimpleClientset(ns("test"), job("test", "j", "Succeeded", time.Now(), nil))
+	calls := map[string]int{}
+	c.PrependReactor("*", "*", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		calls[a.GetVerb()+" "+a.GetResource().Resource]++
+		return false, nil, nil
+	})
+	e := New(c, Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true})
+
+	for i := 0; i < 3; i++ {
+		if _, err := e.Evaluate(context.Background(), "pod", []meta.Object{p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(calls) != 2 || calls["get namespaces"] != 1 || calls["list jobs"] != 1 {
+		t.Fatalf("calls %v, want one namespace and one owner lookup across evaluations", calls)
+	}
+}
+
+type countingObserver struct{ lists, deletes int }
+
+func (o *countingObserver) ObserveList(string, string, time.Duration, error)   { o.lists++ }
+func (o *countingObserver) ObserveDelete(string, string, time.Duration, error) { o.deletes++ }
+
+func Test_Observer_SeesListAndDelete(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		pod("test", "p-ok", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil),
+	)
+	obs := &countingObserver{}
+	e := New(c, Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod", "job"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	}, WithObserver(obs))
+	cands, err := e.FindCandidates(context.Background())
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	for _, cand := range cands {
+		if err := e.Delete(context.Background(), cand); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	if obs.lists != 2 || obs.deletes != 1 {
+		t.Fatalf("lists=%d deletes=%d, want 2 and 1", obs.lists, obs.deletes)
+	}
+}
+
+type recordingBackup struct {
+	saved []string
+	err   error
+}
+
+func (b *recordingBackup) Save(_ context.Context, kind string, obj runtime.Object) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.saved = append(b.saved, kind+"/"+obj.(meta.Object).GetName())
+	return nil
+}
+
+func Test_Delete_SavesBackupFirst(t *testing.T) {
+	c := fake.NewSimpleClientset(ns("test"), job("test", "j", "Succeeded", time.Now().Add(-2*time.Hour), nil))
+	b := &recordingBackup{}
+	e := New(c, Config{}, WithBackup(b))
+	cand := Candidate{Kind: "job", Namespace: "test", Name: "j"}
+
+	b.err = errors.New("disk full")
+	if err := e.Delete(context.Background(), cand); err == nil {
+		t.Fatal("expected delete to fail when backup fails")
+	}
+	if _, err := c.BatchV1().Jobs("test").Get(context.Background(), "j", meta.GetOptions{}); err != nil {
+		t.Fatalf("job must survive a failed backup: %v", err)
+	}
+
+	b.err = nil
+	if err := e.Delete(context.Background(), cand); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(b.saved) != 1 || b.saved[0] != "job/j" {
+		t.Fatalf("saved = %v", b.saved)
+	}
+}
+
+func Test_Recheck(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	p := func(name, uid, rv string, labels map[string]string) *corev1.Pod {
+		o := pod("test", name, corev1.PodSucceeded, "", old, labels)
+		o.UID, o.ResourceVersion = types.UID(uid), rv
+		return o
+	}
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		p("same", "u1", "1", nil),
+		p("recreated", "u9", "5", nil),
+		p("relabelled", "u3", "7", nil),
+		p("protected", "u4", "8", map[string]string{"keep": "true"}),
+	)
+	e := New(c, Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true, ProtectKey: "keep", ProtectVal: "true"})
+
+	for _, tc := range []struct {
+		name, uid, rv, want string
+	}{
+		{"same", "u1", "1", ""},
+		{"gone", "u2", "1", SkipGone},
+		{"recreated", "u2", "1", SkipUIDChanged},
+		{"relabelled", "u3", "6", ""},
+		{"protected", "u4", "2", SkipNoMatch},
+	} {
+		c, got, err := e.Recheck(context.Background(), Candidate{Kind: "pod", Namespace: "test", Name: tc.name, UID: types.UID(tc.uid), ResourceVersion: tc.rv})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if got == "" && tc.name == "relabelled" && c.ResourceVersion != "7" {
+			t.Errorf("%s: resourceVersion %q not refreshed", tc.name, c.ResourceVersion)
+		}
+	}
+}
+
+func Test_Recheck_AppliesSelectors(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	p := func(name, team string) *corev1.Pod {
+		o := pod("test", name, corev1.PodSucceeded, "", old, map[string]string{"team": team})
+		o.UID, o.ResourceVersion = types.UID(name), "2"
+		return o
+	}
+	// Both were planned at resourceVersion 1 with team=ci; one was relabelled.
+	c := fake.NewSimpleClientset(ns("test"), p("still-ci", "ci"), p("moved", "data"))
+	e := New(c, Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true, LabelSelector: "team=ci", FieldSelector: "status.phase=Succeeded"})
+
+	for name, want := range map[string]string{"still-ci": "", "moved": SkipNoMatch} {
+		_, got, err := e.Recheck(context.Background(), Candidate{Kind: "pod", Namespace: "test", Name: name, UID: types.UID(name), ResourceVersion: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func Test_DeleteDetailed_RetriesThrottling(t *testing.T) {
+	c := fake.NewSimpleClientset(ns("test"), pod("test", "p", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil))
+	calls := 0
+	c.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		switch calls {
+		case 1:
+			return true, nil, apierrors.NewTooManyRequests("slow down", 0)
+		case 2:
+			return true, nil, apierrors.NewServiceUnavailable("overloaded")
+		}
+		return false, nil, nil
+	})
+	e := New(c, Config{},
+		WithDeleteRetry(wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}),
+		WithDeleteRateLimit(rate.NewLimiter(rate.Inf, 1)))
+
+	res, err := e.DeleteDetailed(context.Background(), Candidate{Kind: "pod", Namespace: "test", Name: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Retries != 2 || res.Calls != 3 || calls != 3 {
+		t.Fatalf("retries=%d reported calls=%d calls=%d, want 2, 3 and 3", res.Retries, res.Calls, calls)
+	}
+}
+
+func Test_DeleteDetailed_GivesUp(t *testing.T) {
+	c := fake.NewSimpleClientset(ns("test"), pod("test", "p", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil))
+	calls := 0
+	c.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		return true, nil, apierrors.NewInternalError(errors.New("boom"))
+	})
+	e := New(c, Config{}, WithDeleteRetry(wait.Backoff{Duration: time.Millisecond, Steps: 2}))
+
+	res, err := e.DeleteDetailed(context.Background(), Candidate{Kind: "pod", Namespace: "test", Name: "p"})
+	if !apierrors.IsInternalError(err) || res.Retries != 2 || calls != 3 {
+		t.Fatalf("err=%v retries=%d calls=%d", err, res.Retries, calls)
+	}
+
+	calls = 0
+	c.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "p", errors.New("precondition failed"))
+	})
+	_, err = e.DeleteDetailed(context.Background(), Candidate{Kind: "pod", Namespace: "test", Name: "p", UID: "u"})
+	if !errors.Is(err, ErrChanged) || calls != 1 {
+		t.Fatalf("precondition conflicts must not be retried: err=%v calls=%d", err, calls)
+	}
+}
+
+func Test_StreamCandidates_PagesClusterWide(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	pages := [][]corev1.Pod{
+		{*pod("a", "p1", corev1.PodSucceeded, "", old, nil), *pod("kube-system", "p2", corev1.PodSucceeded, "", old, nil)},
+		{*pod("b", "p3", corev1.PodFailed, "", old, nil)},
+	}
+	c := fake.NewSimpleClientset(ns("a"), ns("b"), ns("kube-system"))
+	var calls []string
+	c.PrependReactor("list", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		l := a.(k8stesting.ListActionImpl)
+		calls = append(calls, a.GetNamespace()+"|"+l.ListOptions.Continue)
+		if l.ListOptions.Limit != 2 {
+			t.Errorf("limit = %d, want 2", l.ListOptions.Limit)
+		}
+		if l.ListOptions.Continue == "" {
+			return true, &corev1.PodList{ListMeta: meta.ListMeta{Continue: "next"}, Items: pages[0]}, nil
+		}
+		return true, &corev1.PodList{Items: pages[1]}, nil
+	})
+	e := New(c, Config{
+		OlderThan: time.Hour, Kinds: []string{"pod"}, AllNamespaces: true, ExcludeNamespaces: []string{"kube-system"},
+		IncludeCompleted: true, IncludeFailed: true, PageSize: 2,
+	})
+
+	var got []string
+	err := e.StreamCandidates(context.Background(), func(c Candidate) error {
+		got = append(got, c.Namespace+"/"+c.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "|" || calls[1] != "|next" {
+		t.Fatalf("list calls = %v, want one cluster-wide list in two pages", calls)
+	}
+	if len(got) != 2 || got[0] != "a/p1" || got[1] != "b/p3" {
+		t.Fatalf("candidates = %v", got)
+	}
+	if n := e.Listed()["pod"]; n != 2 {
+		t.Fatalf("listed = %d, want 2 in-scope pods", n)
+	}
+
+	stop := errors.New("stop")
+	if err := e.StreamCandidates(context.Background(), func(Candidate) error { return stop }); !errors.Is(err, stop) {
+		t.Fatalf("want callback error, got %v", err)
+	}
+}
+
+func Test_StreamCandidates_RestartsOnExpiredContinue(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	p1, p2, p3 := pod("a", "p1", corev1.PodSucceeded, "", old, nil), pod("a", "p2", corev1.PodSucceeded, "", old, nil), pod("a", "p3", corev1.PodSucceeded, "", old, nil)
+	c := fake.NewSimpleClientset(ns("a"))
+	var calls []string
+	c.PrependReactor("list", "pods", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		cont := a.(k8stesting.ListActionImpl).ListOptions.Continue
+		calls = append(calls, cont)
+		switch {
+		case cont == "next":
+			return true, nil, apierrors.NewResourceExpired("the provided continue parameter is too old")
+		case len(calls) == 1:
+			return true, &corev1.PodList{ListMeta: meta.ListMeta{Continue: "next"}, Items: []corev1.Pod{*p1, *p2}}, nil
+		default:
+			// p1 was deleted while the token expired.
+			return true, &corev1.PodList{Items: []corev1.Pod{*p2, *p3}}, nil
+		}
+	})
+	e := New(c, Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"a"}, IncludeCompleted: true, PageSize: 2})
+
+	var got []string
+	if err := e.StreamCandidates(context.Background(), func(c Candidate) error {
+		got = append(got, c.Name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 || calls[2] != "" {
+		t.Fatalf("list calls = %q, want the list restarted after the expired token", calls)
+	}
+	if len(got) != 3 || got[0] != "p1" || got[1] != "p2" || got[2] != "p3" {
+		t.Fatalf("candidates = %v, want each pod once", got)
+	}
+}
+
+func Test_FindCandidates_ClockBoundary(t *testing.T) {
+	now := time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC)
+	c := fake.NewSimpleClientset(ns("test"),
+		pod("test", "exact", corev1.PodSucceeded, "", now.Add(-24*time.Hour), nil),
+		pod("test", "second-short", corev1.PodSucceeded, "", now.Add(-24*time.Hour+time.Second), nil),
+	)
+	e := New(c, Config{OlderThan: 24 * time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true}, WithClock(FixedClock(now)))
+
+	cands, err := e.FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cands) != 1 || cands[0].Name != "exact" || cands[0].Age != 24*time.Hour {
+		t.Fatalf("candidates %+v, want only exact at age 24h", cands)
+	}
+	if !e.Now().Equal(now) {
+		t.Fatalf("engine clock %s, want %s", e.Now(), now)
+	}
+}
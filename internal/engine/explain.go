@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Step results.
+const (
+	StepPass = "pass"
+	StepFail = "fail"
+	StepSkip = "skip"
+)
+
+// Explanation walks one object through the filters FindCandidates applies.
+type Explanation struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Candidate bool   `json:"candidate"`
+	Steps     []Step `json:"steps"`
+}
+
+// Step is one filter and the values it decided on.
+type Step struct {
+	Check  string `json:"check"`
+	Result string `json:"result"`
+	Detail string `json:"detail"`
+}
+
+// Explain reports, filter by filter in the order FindCandidates applies them,
+// why the named object is or is not a candidate. Checks after the first that
+// fails are skipped, as they are when deciding.
+//
+// Pods and Jobs are fetched on their own; their siblings are only listed when
+// Config.KeepLast needs them. Other kinds are classified from a list of the
+// namespace, since their state can depend on other objects. Selectors are
+// evaluated locally; field selectors know metadata.name, metadata.namespace
+// and the pod and job fields the API server supports.
+func (e *Engine) Explain(ctx context.Context, kind, ns, name string) (Explanation, error) {
+	k, err := e.resolveKind(kind)
+	if err != nil {
+		return Explanation{}, err
+	}
+	if k.ClusterScoped {
+		ns = metav1.NamespaceNone
+	}
+	rules, err := e.compileRules()
+	if err != nil {
+		return Explanation{}, err
+	}
+	e.resetCaches()
+	it, siblings, err := e.explainItem(ctx, k, ns, name)
+	if err != nil {
+		return Explanation{}, err
+	}
+	now := e.Now()
+
+	x := Explanation{Kind: k.Name, Namespace: ns, Name: name, State: it.State}
+	failed := ""
+	add := func(check string, ok bool, format string, args ...interface{}) {
+		result := StepFail
+		switch {
+		case failed != "":
+			result, format, args = StepSkip, "not checked, %s failed", []interface{}{failed}
+		case ok:
+			result = StepPass
+		default:
+			failed = check
+		}
+		x.Steps = append(x.Steps, Step{Check: check, Result: result, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case k.ClusterScoped:
+		add("namespace", true, "cluster-scoped")
+	case e.cfg.AllNamespaces:
+		add("namespace", e.InScope(ns), "%s with all namespaces, excluding %v", ns, e.cfg.ExcludeNamespaces)
+	default:
+		scope, _ := e.resolveNamespaces(ctx)
+		add("namespace", e.InScope(ns), "%s against namespaces %v", ns, scope)
+	}
+
+	labelOK, fieldOK, selErr := e.selected(it)
+	switch {
+	case e.cfg.LabelSelector == "":
+		add("labelSelector", true, "none set")
+	case selErr != nil:
+		add("labelSelector", false, "%v", selErr)
+	default:
+		add("labelSelector", labelOK, "%q against labels %v", e.cfg.LabelSelector, it.Object.GetLabels())
+	}
+	switch {
+	case e.cfg.FieldSelector == "":
+		add("fieldSelector", true, "none set")
+	case selErr != nil:
+		add("fieldSelector", false, "%v", selErr)
+	default:
+		add("fieldSelector", fieldOK, "%q against %v", e.cfg.FieldSelector, objectFields(it))
+	}
+
+	kept := false
+	if e.cfg.KeepLast > 0 {
+		for i, kk := range e.keepLast(siblings) {
+			if kk && siblings[i].Object.GetName() == name {
+				kept = true
+			}
+		}
+	}
+	v, err := e.judge(ctx, rules, k, it, kept)
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	if e.cfg.KeepLast <= 0 {
+		add("keepLast", true, "not set")
+	} else {
+		add("keepLast", !v.kept, "kept=%t among the newest %d per %s", v.kept, e.cfg.KeepLast, ownerGroup(it, e.cfg.KeepLastLabel))
+	}
+
+	switch r := v.rule; {
+	case r == nil && len(e.cfg.Rules) == 0:
+		add("rule", false, "kind %s not in kinds %v", k.Name, e.cfg.Kinds)
+	case r == nil:
+		add("rule", false, "no policy rule matches kind, namespace and labels")
+	case r.Name == "":
+		add("rule", true, "kind %s in kinds %v", k.Name, e.cfg.Kinds)
+	default:
+		add("rule", true, "first matching rule is %q", r.Name)
+	}
+
+	if r := v.rule; r == nil || r.ProtectKey == "" {
+		add("protect", true, "no protect label")
+	} else {
+		label := r.ProtectKey
+		if r.ProtectVal != "" {
+			label += "=" + r.ProtectVal
+		}
+		detail := "label " + r.ProtectKey + " not set"
+		if val, ok := it.Object.GetLabels()[r.ProtectKey]; ok {
+			detail = fmt.Sprintf("label %s=%q", r.ProtectKey, val)
+		}
+		add("protect", !v.protected, "protect %s, %s", label, detail)
+	}
+
+	if r := v.rule; r == nil {
+		add("state", false, "")
+	} else {
+		states := r.States
+		if len(states) == 0 {
+			states = e.includedStates(k)
+		}
+		add("state", v.stateOK, "state %s, selected states %s", it.State, strings.Join(states, ","))
+	}
+
+	if !v.selected {
+		add("age", false, "")
+	} else {
+		due := v.ttl.due(it.RefTime)
+		limit := "expires at " + v.ttl.expireAt.UTC().Format(time.RFC3339)
+		if v.ttl.expireAt.IsZero() {
+			limit = "max age " + v.ttl.maxAge.String()
+		}
+		add("age", !due.After(now), "reference time %s (age %s), %s from %s, due at %s",
+			it.RefTime.UTC().Format(time.RFC3339), now.Sub(it.RefTime).Round(time.Second), limit, v.ttl.source, due.UTC().Format(time.RFC3339))
+	}
+
+	x.Candidate = failed == ""
+	return x, nil
+}
+
+// explainItem fetches and classifies one object. With Config.KeepLast it also
+// returns the objects listed alongside it, filtered like a run would be.
+func (e *Engine) explainItem(ctx context.Context, k Kind, ns, name string) (Item, []Item, error) {
+	notFound := apierrors.NewNotFound(schema.GroupResource{Resource: k.Name}, name)
+	if k.Get == nil || k.Classify == nil {
+		items, err := k.List(ctx, e, ns, metav1.ListOptions{})
+		if err != nil {
+			return Item{}, nil, err
+		}
+		for _, it := range items {
+			if it.Object.GetName() == name {
+				return it, e.selectedItems(items), nil
+			}
+		}
+		return Item{}, nil, notFound
+	}
+
+	obj, err := k.Get(ctx, e, ns, name)
+	if err != nil {
+		return Item{}, nil, err
+	}
+	m, ok := obj.(metav1.Object)
+	if !ok {
+		return Item{}, nil, notFound
+	}
+	it := k.Classify(m)
+	if e.cfg.KeepLast <= 0 || ownerGroup(it, e.cfg.KeepLastLabel) == "" {
+		return it, nil, nil
+	}
+	opts := metav1.ListOptions{LabelSelector: e.cfg.LabelSelector, FieldSelector: e.cfg.FieldSelector}
+	list := k.List
+	if k.ListPage != nil {
+		opts.Limit = e.pageSize()
+		list = pagedList(k.ListPage)
+	}
+	siblings, err := list(ctx, e, ns, opts)
+	return it, siblings, err
+}
+
+// selectedItems keeps the items the configured selectors match.
+func (e *Engine) selectedItems(items []Item) []Item {
+	var out []Item
+	for _, it := range items {
+		if l, f, err := e.selected(it); err == nil && l && f {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+// selected evaluates the label and field selectors against one item.
+func (e *Engine) selected(it Item) (labelOK, fieldOK bool, err error) {
+	ls, err := labels.Parse(e.cfg.LabelSelector)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid label selector %q: %w", e.cfg.LabelSelector, err)
+	}
+	fs, err := fields.ParseSelector(e.cfg.FieldSelector)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid field selector %q: %w", e.cfg.FieldSelector, err)
+	}
+	return ls.Matches(labels.Set(it.Object.GetLabels())), fs.Matches(objectFields(it)), nil
+}
+
+// objectFields are the selectable fields of an object, as the API server
+// exposes them for field selectors.
+func objectFields(it Item) fields.Set {
+	f := fields.Set{
+		"metadata.name":      it.Object.GetName(),
+		"metadata.namespace": it.Object.GetNamespace(),
+	}
+	switch o := it.Object.(type) {
+	case *corev1.Pod:
+		f["spec.nodeName"] = o.Spec.NodeName
+		f["spec.restartPolicy"] = string(o.Spec.RestartPolicy)
+		f["spec.schedulerName"] = o.Spec.SchedulerName
+		f["spec.serviceAccountName"] = o.Spec.ServiceAccountName
+		f["spec.hostNetwork"] = strconv.FormatBool(o.Spec.HostNetwork)
+		f["status.phase"] = string(o.Status.Phase)
+		f["status.podIP"] = o.Status.PodIP
+		f["status.nominatedNodeName"] = o.Status.NominatedNodeName
+	case *batchv1.Job:
+		f["status.successful"] = strconv.Itoa(int(o.Status.Succeeded))
+	}
+	return f
+}
+
+// includedStates lists the states stateIncluded accepts for k.
+func (e *Engine) includedStates(k Kind) []string {
+	out := append([]string(nil), k.Selects...)
+	if e.cfg.IncludeCompleted {
+		out = append(out, "Succeeded")
+	}
+	if e.cfg.IncludeFailed {
+		out = append(out, "Failed")
+	}
+	if e.cfg.IncludeEvicted {
+		out = append(out, "Evicted")
+	}
+	if e.cfg.TerminatingAfter > 0 {
+		out = append(out, StateTerminating)
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_Explain(t *testing.T) {
+	now := time.Now()
+	c := fake.NewSimpleClientset(ns("test"),
+		pod("test", "due", corev1.PodSucceeded, "", now.Add(-48*time.Hour), nil),
+		pod("test", "young", corev1.PodSucceeded, "", now.Add(-time.Hour), nil),
+		pod("test", "kept", corev1.PodSucceeded, "", now.Add(-48*time.Hour), map[string]string{"keep": "true"}),
+		pod("test", "running", corev1.PodRunning, "", now.Add(-48*time.Hour), nil),
+		job("test", "done", "Succeeded", now.Add(-48*time.Hour), nil),
+	)
+	lists := 0
+	c.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+	e := New(c, Config{OlderThan: 24 * time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true, ProtectKey: "keep", ProtectVal: "true"})
+	ctx := context.Background()
+
+	for name, failed := range map[string]string{"due": "", "young": "age", "kept": "protect", "running": "state"} {
+		x, err := e.Explain(ctx, "pods", "test", name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if x.Candidate != (failed == "") {
+			t.Errorf("%s: candidate=%t, steps %+v", name, x.Candidate, x.Steps)
+		}
+		want := StepPass
+		for _, s := range x.Steps {
+			if s.Check == failed {
+				want = StepFail
+			}
+			if s.Result != want {
+				t.Errorf("%s: step %s is %s (%s), want %s", name, s.Check, s.Result, s.Detail, want)
+			}
+			if want == StepFail {
+				want = StepSkip
+			}
+		}
+	}
+	if lists != 0 {
+		t.Errorf("explaining pods listed %d times, want a single GET each", lists)
+	}
+
+	x, err := e.Explain(ctx, "job", "test", "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Candidate || x.Steps[4].Check != "rule" || x.Steps[4].Result != StepFail || x.Steps[5].Result != StepSkip {
+		t.Fatalf("job not in kinds: %+v", x.Steps)
+	}
+	if _, err := e.Explain(ctx, "pod", "test", "missing"); !apierrors.IsNotFound(err) {
+		t.Fatalf("missing object: %v", err)
+	}
+}
+
+func Test_Explain_AgreesWithKeepLast(t *testing.T) {
+	now := time.Now()
+	owned := func(name string, age time.Duration) *corev1.Pod {
+		p := pod("test", name, corev1.PodSucceeded, "", now.Add(-age), nil)
+		yes := true
+		p.OwnerReferences = []meta.OwnerReference{{Kind: "Job", Name: "j", Controller: &yes}}
+		return p
+	}
+	c := fake.NewSimpleClientset(ns("test"), owned("newest", 48*time.Hour), owned("older", 72*time.Hour))
+	e := New(c, Config{OlderThan: 24 * time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true, KeepLast: 1})
+	ctx := context.Background()
+
+	cands, err := e.FindCandidates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected := map[string]bool{}
+	for _, c := range cands {
+		selected[c.Name] = true
+	}
+	for _, name := range []string{"newest", "older"} {
+		x, err := e.Explain(ctx, "pod", "test", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x.Candidate != selected[name] {
+			t.Errorf("%s: explain says candidate=%t, FindCandidates %t: %+v", name, x.Candidate, selected[name], x.Steps)
+		}
+	}
+	if selected["newest"] || !selected["older"] {
+		t.Fatalf("candidates %+v, want only older", cands)
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Item is a listed object reduced to what the filters need. MaxAge overrides
+// Config.OlderThan for this object when non-zero.
+type Item struct {
+	Object  metav1.Object
+	State   string
+	RefTime time.Time
+	MaxAge  time.Duration
+}
+
+// Kind teaches the engine how to list, classify and delete one resource type.
+// Selects names states this kind always selects, on top of the
+// completed/failed/evicted switches in Config. Get fetches the live object for
+// backups. Classify is optional and lets a single watched object be evaluated
+// without listing. ListPage is optional too: it lists one page and returns the
+// continue token. Kinds that have it classify each object on its own, so they
+// are listed page by page and, with Config.AllNamespaces, across all
+// namespaces at once. Resource, when set, lets WithMetadata list the kind
+// through the metadata API. Patch is needed to mark objects for deletion.
+type Kind struct {
+	Name          string
+	Aliases       []string
+	ClusterScoped bool
+	Selects       []string
+	List          func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error)
+	ListPage      func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error)
+	Get           func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error)
+	Delete        func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error
+	Patch         func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error
+	Classify      func(obj metav1.Object) Item
+	Resource      schema.GroupVersionResource
+}
+
+type Registry struct {
+	mu    sync.RWMutex
+	kinds map[string]Kind
+}
+
+func NewRegistry(kinds ...Kind) *Registry {
+	r := &Registry{kinds: map[string]Kind{}}
+	for _, k := range kinds {
+		r.Register(k)
+	}
+	return r
+}
+
+// DefaultRegistry returns a registry holding the built-in kinds.
+func DefaultRegistry() *Registry {
+	return NewRegistry(builtinKinds()...)
+}
+
+func (r *Registry) Register(k Kind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.kinds[helpers.NormalizeKind(k.Name)] = k
+	for _, a := range k.Aliases {
+		r.kinds[helpers.NormalizeKind(a)] = k
+	}
+}
+
+func (r *Registry) Lookup(name string) (Kind, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	k, ok := r.kinds[helpers.NormalizeKind(name)]
+	return k, ok
+}
+
+func builtinKinds() []Kind {
+	return []Kind{
+		podKind(),
+		jobKind(),
+		replicaSetKind(),
+		configMapKind(),
+		secretKind(),
+		pvcKind(),
+		pvKind(),
+		DynamicKind("workflows.argoproj.io", argoWorkflows, GenericState, GenericRefTime),
+		DynamicKind("pipelineruns.tekton.dev", tektonPipelineRuns, GenericState, GenericRefTime),
+		DynamicKind("taskruns.tekton.dev", tektonTaskRuns, GenericState, GenericRefTime),
+	}
+}
+
+// pagedList adapts a ListPage func to List by following continue tokens.
+func pagedList(page func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error)) func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error) {
+	return func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error) {
+		var out []Item
+		for {
+			items, cont, err := page(ctx, e, ns, opts)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, items...)
+			if cont == "" {
+				return out, nil
+			}
+			opts.Continue = cont
+		}
+	}
+}
+
+func podKind() Kind {
+	page := func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error) {
+		list, err := e.kube.CoreV1().Pods(ns).List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		out := make([]Item, 0, len(list.Items))
+		for i := range list.Items {
+			out = append(out, podItem(&list.Items[i]))
+		}
+		return out, list.Continue, nil
+	}
+	return Kind{
+		Name:     "pod",
+		Resource: corev1.SchemeGroupVersion.WithResource("pods"),
+		List:     pagedList(page),
+		ListPage: page,
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			return e.kube.CoreV1().Pods(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			return e.kube.CoreV1().Pods(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.CoreV1().Pods(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+		Classify: func(obj metav1.Object) Item {
+			return podItem(obj.(*corev1.Pod))
+		},
+	}
+}
+
+func podItem(p *corev1.Pod) Item {
+	ts := p.CreationTimestamp.Time
+	if p.Status.StartTime != nil {
+		ts = p.Status.StartTime.Time
+	}
+	if p.DeletionTimestamp != nil {
+		ts = p.DeletionTimestamp.Time
+	}
+	return Item{Object: p, State: helpers.PodState(p), RefTime: ts}
+}
+
+func jobKind() Kind {
+	page := func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error) {
+		list, err := e.kube.BatchV1().Jobs(ns).List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		out := make([]Item, 0, len(list.Items))
+		for i := range list.Items {
+			out = append(out, jobItem(&list.Items[i]))
+		}
+		return out, list.Continue, nil
+	}
+	return Kind{
+		Name:     "job",
+		Resource: batchv1.SchemeGroupVersion.WithResource("jobs"),
+		List:     pagedList(page),
+		ListPage: page,
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			return e.kube.BatchV1().Jobs(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			return e.kube.BatchV1().Jobs(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.BatchV1().Jobs(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+		Classify: func(obj metav1.Object) Item {
+			return jobItem(obj.(*batchv1.Job))
+		},
+	}
+}
+
+func jobItem(j *batchv1.Job) Item {
+	return Item{Object: j, State: helpers.JobState(j), RefTime: helpers.JobRefTime(j)}
+}
+
+func (e *Engine) resolveKind(name string) (Kind, error) {
+	if k, ok := e.kinds.Lookup(name); ok {
+		return k, nil
+	}
+	if e.mapper == nil {
+		return Kind{}, fmt.Errorf("unknown kind %q", name)
+	}
+	k, err := e.discoverKind(name)
+	if err != nil {
+		return Kind{}, err
+	}
+	e.kinds.Register(k)
+	return k, nil
+}
@@ -0,0 +1,30 @@
+package engine
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_Registry_Lookup(t *testing.T) {
+	r := DefaultRegistry()
+	for _, name := range []string{"pod", "Pods", "job", "jobs", "workflows.argoproj.io", "pipelineruns.tekton.dev"} {
+		if _, ok := r.Lookup(name); !ok {
+			t.Fatalf("builtin kind %q not registered", name)
+		}
+	}
+	if _, ok := r.Lookup("widget"); ok {
+		t.Fatal("unexpected kind widget")
+	}
+	r.Register(Kind{Name: "widgets.example.com", Aliases: []string{"widget"}})
+	k, ok := r.Lookup("widgets")
+	if !ok || k.Name != "widgets.example.com" {
+		t.Fatalf("alias lookup failed: %+v", k)
+	}
+}
+
+func Test_FindCandidates_UnknownKind(t *testing.T) {
+	e := New(nil, Config{Kinds: []string{"widget"}})
+	if _, err := e.FindCandidates(context.Background()); err == nil {
+		t.Fatal("want error for unknown kind")
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Limits cap how much a single run may delete. Zero disables a limit.
+type Limits struct {
+	MaxDeletions             int
+	MaxDeletionsPerNamespace int
+	// MaxDeletionPercent is relative to the number of listed objects of each
+	// kind, rounded up so that kinds with few objects still lose one per
+	// run. Kinds without a listed count are not limited by it.
+	MaxDeletionPercent float64
+}
+
+// LimitError reports the first limit a set of candidates exceeds.
+type LimitError struct {
+	Limit string
+	Scope string
+	Count int
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	if e.Scope == "" {
+		return fmt.Sprintf("%d candidates exceed %s of %d", e.Count, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%d candidates in %s exceed %s of %d", e.Count, e.Scope, e.Limit, e.Max)
+}
+
+// Check returns a *LimitError if cands exceed any limit. listed holds the
+// number of listed objects per kind, as returned by Engine.Listed.
+func (l Limits) Check(cands []Candidate, listed map[string]int) error {
+	if l.MaxDeletions > 0 && len(cands) > l.MaxDeletions {
+		return &LimitError{Limit: "max-deletions", Count: len(cands), Max: l.MaxDeletions}
+	}
+	perNS, perKind := map[string]int{}, map[string]int{}
+	for _, c := range cands {
+		perNS[c.Namespace]++
+		perKind[c.Kind]++
+	}
+	if l.MaxDeletionsPerNamespace > 0 {
+		for _, ns := range sortedKeys(perNS) {
+			if perNS[ns] > l.MaxDeletionsPerNamespace {
+				return &LimitError{Limit: "max-deletions-per-namespace", Scope: "namespace " + ns, Count: perNS[ns], Max: l.MaxDeletionsPerNamespace}
+			}
+		}
+	}
+	for _, k := range sortedKeys(perKind) {
+		if max, ok := l.percentOf(listed, k); ok && perKind[k] > max {
+			return &LimitError{Limit: "max-deletion-percent", Scope: "kind " + k, Count: perKind[k], Max: max}
+		}
+	}
+	return nil
+}
+
+// Truncate keeps the oldest candidates that fit within every limit and returns
+// them along with the ones left out.
+func (l Limits) Truncate(cands []Candidate, listed map[string]int) (kept, dropped []Candidate) {
+	sorted := append([]Candidate(nil), cands...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Age > sorted[j].Age })
+	perNS, perKind := map[string]int{}, map[string]int{}
+	for _, c := range sorted {
+		fits := (l.MaxDeletions <= 0 || len(kept) < l.MaxDeletions) &&
+			(l.MaxDeletionsPerNamespace <= 0 || perNS[c.Namespace] < l.MaxDeletionsPerNamespace) &&
+			l.underPercent(listed, c.Kind, perKind[c.Kind])
+		if !fits {
+			dropped = append(dropped, c)
+			continue
+		}
+		kept = append(kept, c)
+		perNS[c.Namespace]++
+		perKind[c.Kind]++
+	}
+	return kept, dropped
+}
+
+func (l Limits) percentOf(listed map[string]int, kind string) (int, bool) {
+	n := listed[kind]
+	if l.MaxDeletionPercent <= 0 || n == 0 {
+		return 0, false
+	}
+	// The epsilon keeps exact products such as 30% of 10 from rounding up
+	// to 4 through float error.
+	return int(math.Ceil(float64(n)*l.MaxDeletionPercent/100 - 1e-9)), true
+}
+
+func (l Limits) underPercent(listed map[string]int, kind string, count int) bool {
+	max, ok := l.percentOf(listed, kind)
+	return !ok || count < max
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func limitCands() []Candidate {
+	return []Candidate{
+		{Kind: "pod", Namespace: "a", Name: "a1", Age: 1 * time.Hour},
+		{Kind: "pod", Namespace: "a", Name: "a2", Age: 5 * time.Hour},
+		{Kind: "pod", Namespace: "a", Name: "a3", Age: 3 * time.Hour},
+		{Kind: "job", Namespace: "b", Name: "b1", Age: 2 * time.Hour},
+	}
+}
+
+func Test_Limits_Check(t *testing.T) {
+	listed := map[string]int{"pod": 10, "job": 2}
+	for _, tc := range []struct {
+		name   string
+		limits Limits
+		want   string
+	}{
+		{"none", Limits{}, ""},
+		{"total ok", Limits{MaxDeletions: 4}, ""},
+		{"total", Limits{MaxDeletions: 3}, "max-deletions"},
+		{"namespace", Limits{MaxDeletionsPerNamespace: 2}, "max-deletions-per-namespace"},
+		{"percent ok", Limits{MaxDeletionPercent: 50}, ""},
+		{"percent", Limits{MaxDeletionPercent: 20}, "max-deletion-percent"},
+	} {
+		err := tc.limits.Check(limitCands(), listed)
+		var le *LimitError
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: unexpected %v", tc.name, err)
+		case tc.want != "" && (!errors.As(err, &le) || le.Limit != tc.want):
+			t.Errorf("%s: got %v, want %s", tc.name, err, tc.want)
+		}
+	}
+}
+
+func Test_Limits_TruncateKeepsOldest(t *testing.T) {
+	kept, dropped := Limits{MaxDeletions: 3, MaxDeletionsPerNamespace: 2}.Truncate(limitCands(), nil)
+	var names []string
+	for _, c := range kept {
+		names = append(names, c.Name)
+	}
+	if len(kept) != 3 || names[0] != "a2" || names[1] != "a3" || names[2] != "b1" {
+		t.Fatalf("kept = %v", names)
+	}
+	if len(dropped) != 1 || dropped[0].Name != "a1" {
+		t.Fatalf("dropped = %v", dropped)
+	}
+}
+
+func Test_Limits_PercentIgnoresUnlistedKinds(t *testing.T) {
+	kept, dropped := Limits{MaxDeletionPercent: 20}.Truncate(limitCands(), map[string]int{"pod": 10})
+	if len(kept) != 3 || len(dropped) != 1 || dropped[0].Name != "a1" {
+		t.Fatalf("kept = %v, dropped = %v", kept, dropped)
+	}
+}
+
+func Test_Limits_PercentRoundsUpForSmallKinds(t *testing.T) {
+	listed := map[string]int{"pod": 7, "job": 2}
+	l := Limits{MaxDeletionPercent: 10}
+	kept, dropped := l.Truncate(limitCands(), listed)
+	if len(kept) != 2 || kept[0].Name != "a2" || kept[1].Name != "b1" || len(dropped) != 2 {
+		t.Fatalf("kept = %v, dropped = %v, want the oldest pod and the job", kept, dropped)
+	}
+	if err := l.Check(limitCands()[3:], listed); err != nil {
+		t.Fatalf("one of two jobs at 10%%: %v", err)
+	}
+	var le *LimitError
+	if err := l.Check(limitCands(), listed); !errors.As(err, &le) || le.Max != 1 {
+		t.Fatalf("three of seven pods at 10%%: %v, want max 1", err)
+	}
+}
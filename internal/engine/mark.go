@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ScheduledDeletionAnnotation marks an object for deletion at the RFC3339
+// time it holds, giving owners a notice period to protect it.
+const ScheduledDeletionAnnotation = "k8s-cleanup.io/scheduled-deletion"
+
+func scheduledAt(obj metav1.Object) time.Time {
+	v := obj.GetAnnotations()[ScheduledDeletionAnnotation]
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Mark sets ScheduledDeletionAnnotation on the object c was listed from to at.
+// Like Delete it fails with ErrChanged if the object changed since.
+func (e *Engine) Mark(ctx context.Context, c Candidate, at time.Time) error {
+	v := at.UTC().Format(time.RFC3339)
+	return e.annotate(ctx, c, &v)
+}
+
+// Unmark removes ScheduledDeletionAnnotation from the object c was listed from.
+func (e *Engine) Unmark(ctx context.Context, c Candidate) error {
+	return e.annotate(ctx, c, nil)
+}
+
+// annotate sets or, with a nil value, removes the mark with a merge patch. The
+// UID and resourceVersion in the patch act as preconditions.
+func (e *Engine) annotate(ctx context.Context, c Candidate, value *string) error {
+	k, err := e.resolveKind(c.Kind)
+	if err != nil {
+		return err
+	}
+	if k.Patch == nil {
+		return fmt.Errorf("kind %q cannot be marked", k.Name)
+	}
+	md := map[string]interface{}{
+		"annotations": map[string]interface{}{ScheduledDeletionAnnotation: value},
+	}
+	if c.UID != "" {
+		md["uid"] = c.UID
+	}
+	if c.ResourceVersion != "" {
+		md["resourceVersion"] = c.ResourceVersion
+	}
+	data, err := json.Marshal(map[string]interface{}{"metadata": md})
+	if err != nil {
+		return err
+	}
+	if e.limiter != nil {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	err = k.Patch(ctx, e, c.Namespace, c.Name, types.MergePatchType, data)
+	if apierrors.IsConflict(err) {
+		return fmt.Errorf("%w: %v", ErrChanged, err)
+	}
+	return err
+}
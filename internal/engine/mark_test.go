@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_MarkUnmark(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	c := fake.NewSimpleClientset(ns("test"), pod("test", "p", corev1.PodSucceeded, "", old, nil))
+	e := New(c, Config{OlderThan: 24 * time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true})
+	ctx := context.Background()
+
+	cands, err := e.FindCandidates(ctx)
+	if err != nil || len(cands) != 1 || !cands[0].ScheduledAt.IsZero() {
+		t.Fatalf("candidates %+v, %v", cands, err)
+	}
+	at := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	if err := e.Mark(ctx, cands[0], at); err != nil {
+		t.Fatal(err)
+	}
+
+	var ds []Decision
+	if err := e.StreamDecisions(ctx, func(d Decision) error {
+		ds = append(ds, d)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || !ds[0].ScheduledAt.Equal(at) {
+		t.Fatalf("decisions %+v, want the mark %s", ds, at)
+	}
+
+	if err := e.Unmark(ctx, ds[0].Candidate); err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.CoreV1().Pods("test").Get(ctx, "p", meta.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Annotations[ScheduledDeletionAnnotation]; ok {
+		t.Fatalf("mark not removed: %v", p.Annotations)
+	}
+
+	c.PrependReactor("patch", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, "p", errors.New("resourceVersion changed"))
+	})
+	if err := e.Mark(ctx, ds[0].Candidate, at); !errors.Is(err, ErrChanged) {
+		t.Fatalf("marking a changed object: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/metadata"
+)
+
+// WithMetadata lists kinds that set Resource through the metadata API. Only
+// objects whose labels, owner and creation time leave a chance of being due
+// are then fetched in full, which saves memory and bandwidth when most objects
+// are young or protected. It has no effect with Config.KeepLast, which needs
+// the state of every object.
+func WithMetadata(m metadata.Interface) Option {
+	return func(e *Engine) {
+		e.meta = m
+	}
+}
+
+func (e *Engine) usesMetadata(k Kind) bool {
+	return e.meta != nil && !k.Resource.Empty() && k.ListPage != nil && k.Classify != nil && k.Get != nil && e.cfg.KeepLast == 0
+}
+
+// hydrateGets is how many objects of a metadata page are fetched one by one.
+// Pages with more objects to hydrate are listed again in full instead.
+const hydrateGets = 10
+
+// metadataPage lists one page of k as PartialObjectMetadata. Items carry the
+// creation time as RefTime and no state; the ones that may be due are replaced
+// with classified full objects, see hydrate. Terminating pods are aged from
+// their deletionTimestamp. Once a page had to be listed again in full, the
+// metadata saved nothing, so the rest of k is listed in full up front instead
+// of downloading each page twice.
+func (e *Engine) metadataPage(k Kind, rules []compiledRule, now time.Time) func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error) {
+	full := false
+	return func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, string, error) {
+		if full {
+			return k.ListPage(ctx, e, ns, opts)
+		}
+		list, err := e.meta.Resource(k.Resource).Namespace(ns).List(ctx, opts)
+		if err != nil {
+			return nil, "", err
+		}
+		out := make([]Item, 0, len(list.Items))
+		for i := range list.Items {
+			m := &list.Items[i]
+			it := Item{Object: m, RefTime: m.CreationTimestamp.Time}
+			if m.DeletionTimestamp != nil && k.Name == "pod" {
+				it.State, it.RefTime = StateTerminating, m.DeletionTimestamp.Time
+			}
+			out = append(out, it)
+		}
+		full, err = e.hydrate(ctx, rules, k, ns, opts, out, now)
+		if err != nil {
+			return nil, "", err
+		}
+		return out, list.Continue, nil
+	}
+}
+
+// hydrate replaces the metadata items that a rule selects and that are due
+// when aged from their creation with classified full objects. Reference times
+// are never before creation, so nothing due is left out. A few objects are
+// fetched one by one; more are taken from the same page listed in full, which
+// costs one request instead of one per object, and relisted reports that.
+// Items left as metadata are dropped by fullItems.
+func (e *Engine) hydrate(ctx context.Context, rules []compiledRule, k Kind, ns string, opts metav1.ListOptions, items []Item, now time.Time) (relisted bool, err error) {
+	var due []int
+	for i, it := range items {
+		// A cluster-wide page also holds namespaces listKind filters out.
+		if ns == metav1.NamespaceAll && !e.InScope(it.Object.GetNamespace()) {
+			continue
+		}
+		// Marked objects are always decided, so a stale mark can be removed.
+		if scheduledAt(it.Object).IsZero() {
+			r := matchRule(rules, k, it)
+			if r == nil || protected(r.ProtectKey, r.ProtectVal, it.Object.GetLabels()) {
+				continue
+			}
+			t, err := e.resolveTTL(ctx, r, it)
+			if err != nil {
+				return false, err
+			}
+			if t.due(it.RefTime).After(now) {
+				continue
+			}
+		}
+		due = append(due, i)
+	}
+
+	if len(due) > hydrateGets {
+		full, _, err := k.ListPage(ctx, e, ns, opts)
+		if err != nil {
+			return false, err
+		}
+		relisted = true
+		byName := make(map[string]Item, len(full))
+		for _, it := range full {
+			byName[it.Object.GetNamespace()+"/"+it.Object.GetName()] = it
+		}
+		rest := due[:0]
+		for _, i := range due {
+			m := items[i].Object
+			if it, ok := byName[m.GetNamespace()+"/"+m.GetName()]; ok {
+				items[i] = it
+			} else {
+				// The page moved since it was listed as metadata.
+				rest = append(rest, i)
+			}
+		}
+		due = rest
+	}
+	for _, i := range due {
+		obj, err := k.Get(ctx, e, items[i].Object.GetNamespace(), items[i].Object.GetName())
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if o, ok := obj.(metav1.Object); ok {
+			items[i] = k.Classify(o)
+		}
+	}
+	return relisted, nil
+}
+
+// fullItems drops the metadata items hydrate left alone.
+func fullItems(items []Item) []Item {
+	out := items[:0]
+	for _, it := range items {
+		if _, ok := it.Object.(*metav1.PartialObjectMetadata); !ok {
+			out = append(out, it)
+		}
+	}
+	return out
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves pod lists, as full objects or as metadata depending on
+// the Accept header and paged by limit and continue, and single pod GETs. It
+// counts requests of each sort and the bytes served.
+type fakeAPIServer struct {
+	*httptest.Server
+	lists     atomic.Int64
+	metaLists atomic.Int64
+	gets      atomic.Int64
+	bytes     atomic.Int64
+}
+
+func newFakeAPIServer(t testing.TB, pods []corev1.Pod) *fakeAPIServer {
+	byName := map[string][]byte{}
+	partial := make([]meta.PartialObjectMetadata, 0, len(pods))
+	for i := range pods {
+		p := pods[i]
+		p.TypeMeta = meta.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+		partial = append(partial, meta.PartialObjectMetadata{
+			TypeMeta:   meta.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"},
+			ObjectMeta: p.ObjectMeta,
+		})
+		var err error
+		if byName[p.Namespace+"/"+p.Name], err = json.Marshal(&p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// page returns the bounds of the page asked for and the next continue.
+	page := func(r *http.Request) (int, int, string) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+		to := len(pods)
+		if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); limit > 0 && from+limit < to {
+			to = from + limit
+		}
+		if to == len(pods) {
+			return from, to, ""
+		}
+		return from, to, strconv.Itoa(to)
+	}
+
+	s := &fakeAPIServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		var body []byte
+		var err error
+		switch {
+		case len(parts) == 5 && parts[4] == "pods":
+			from, to, cont := page(r)
+			if strings.Contains(r.Header.Get("Accept"), "as=PartialObjectMetadataList") {
+				s.metaLists.Add(1)
+				body, err = json.Marshal(&meta.PartialObjectMetadataList{
+					TypeMeta: meta.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadataList"},
+					ListMeta: meta.ListMeta{Continue: cont},
+					Items:    partial[from:to],
+				})
+			} else {
+				s.lists.Add(1)
+				body, err = json.Marshal(&corev1.PodList{
+					TypeMeta: meta.TypeMeta{APIVersion: "v1", Kind: "PodList"},
+					ListMeta: meta.ListMeta{Continue: cont},
+					Items:    pods[from:to],
+				})
+			}
+		case len(parts) == 6 && parts[4] == "pods" && byName[parts[3]+"/"+parts[5]] != nil:
+			s.gets.Add(1)
+			body = byName[parts[3]+"/"+parts[5]]
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			body = []byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404}`)
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		s.bytes.Add(int64(len(body)))
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requests is every request served so far.
+func (s *fakeAPIServer) requests() int64 {
+	return s.lists.Load() + s.metaLists.Load() + s.gets.Load()
+}
+
+func (s *fakeAPIServer) engine(t testing.TB, cfg Config, withMetadata bool) *Engine {
+	rc := &rest.Config{Host: s.URL, QPS: -1}
+	kube, err := kubernetes.NewForConfig(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts []Option
+	if withMetadata {
+		mc, err := metadata.NewForConfig(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, WithMetadata(mc))
+	}
+	return New(kube, cfg, opts...)
+}
+
+// benchPods returns n completed pods of realistic size; every dueEvery-th one
+// is old enough to be a candidate.
+func benchPods(n, dueEvery int) []corev1.Pod {
+	now := time.Now()
+	env := make([]corev1.EnvVar, 20)
+	for i := range env {
+		env[i] = corev1.EnvVar{Name: fmt.Sprintf("SETTING_%d", i), Value: strings.Repeat("x", 40)}
+	}
+	out := make([]corev1.Pod, 0, n)
+	for i := 0; i < n; i++ {
+		started := now.Add(-10 * time.Minute)
+		if i%dueEvery == 0 {
+			started = now.Add(-48 * time.Hour)
+		}
+		p := pod("work", fmt.Sprintf("job-%05d-abcde", i), corev1.PodSucceeded, "", started, map[string]string{"app": "batch", "job-name": fmt.Sprintf("job-%05d", i)})
+		p.UID = "uid"
+		p.CreationTimestamp = meta.NewTime(started.Add(-time.Second))
+		for _, name := range []string{"main", "sidecar"} {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: name, Image: "registry.example.com/team/" + name + ":1.2.3", Env: env})
+			p.Status.ContainerStatuses = append(p.Status.ContainerStatuses, corev1.ContainerStatus{
+				Name: name, Image: "registry.example.com/team/" + name + ":1.2.3", ImageID: "sha256:" + strings.Repeat("0", 64),
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"}},
+			})
+		}
+		out = append(out, *p)
+	}
+	return out
+}
+
+var metadataCfg = Config{OlderThan: 24 * time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"work"}, IncludeCompleted: true, ProtectKey: "keep", ProtectVal: "true"}
+
+func Test_Metadata_SameCandidatesFewerObjects(t *testing.T) {
+	pods := benchPods(100, 10)
+	pods[20].Labels["keep"] = "true"
+	srv := newFakeAPIServer(t, pods)
+
+	names := func(e *Engine) []string {
+		list, err := e.FindCandidates(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, c := range list {
+			out = append(out, c.Name)
+		}
+		sort.Strings(out)
+		return out
+	}
+	want := names(srv.engine(t, metadataCfg, false))
+	got := names(srv.engine(t, metadataCfg, true))
+	if len(want) != 9 || strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("metadata candidates %v, want %v", got, want)
+	}
+	if n := srv.gets.Load(); n != 9 {
+		t.Fatalf("fetched %d full pods, want only the 9 old unprotected ones", n)
+	}
+}
+
+func Test_Metadata_HydratesManyWithOneList(t *testing.T) {
+	srv := newFakeAPIServer(t, benchPods(100, 10))
+	cfg := metadataCfg
+	cfg.OlderThan = 5 * time.Minute
+
+	list, err := srv.engine(t, cfg, true).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 100 {
+		t.Fatalf("got %d candidates, want 100", len(list))
+	}
+	if gets, lists := srv.gets.Load(), srv.lists.Load(); gets != 0 || lists != 1 {
+		t.Fatalf("hydrated with %d GETs and %d full lists, want 0 and 1", gets, lists)
+	}
+}
+
+func Test_Metadata_ListsInFullAfterARelist(t *testing.T) {
+	srv := newFakeAPIServer(t, benchPods(100, 10))
+	cfg := metadataCfg
+	cfg.OlderThan = 5 * time.Minute
+	cfg.PageSize = 20
+
+	list, err := srv.engine(t, cfg, true).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 100 {
+		t.Fatalf("got %d candidates, want 100", len(list))
+	}
+	if meta, full := srv.metaLists.Load(), srv.lists.Load(); meta != 1 || full != 5 {
+		t.Fatalf("%d metadata and %d full lists, want only the first page as metadata", meta, full)
+	}
+}
+
+func Benchmark_FindCandidates_Pods(b *testing.B) {
+	for _, due := range []int{10, 100} {
+		srv := newFakeAPIServer(b, benchPods(2000, due))
+		for _, bc := range []struct {
+			name     string
+			metadata bool
+		}{
+			{"full-list", false},
+			{"metadata", true},
+		} {
+			b.Run(fmt.Sprintf("due=1in%d/%s", due, bc.name), func(b *testing.B) {
+				e := srv.engine(b, metadataCfg, bc.metadata)
+				b.ReportAllocs()
+				requests, bytes := srv.requests(), srv.bytes.Load()
+				for i := 0; i < b.N; i++ {
+					if _, err := e.FindCandidates(context.Background()); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(srv.requests()-requests)/float64(b.N), "requests/op")
+				b.ReportMetric(float64(srv.bytes.Load()-bytes)/float64(b.N), "served-B/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule is one entry of a policy. Empty fields fall back to the matching
+// Config field, so a rule only needs to state what differs.
+type Rule struct {
+	Name              string
+	Namespaces        []string
+	ExcludeNamespaces []string
+	LabelSelector     string
+	Kinds             []string
+	States            []string
+	OlderThan         time.Duration
+	ProtectKey        string
+	ProtectVal        string
+}
+
+type policyFile struct {
+	Rules []policyRule `json:"rules"`
+}
+
+type policyRule struct {
+	Name              string   `json:"name"`
+	Namespaces        []string `json:"namespaces"`
+	ExcludeNamespaces []string `json:"excludeNamespaces"`
+	LabelSelector     string   `json:"labelSelector"`
+	Kinds             []string `json:"kinds"`
+	States            []string `json:"states"`
+	OlderThan         string   `json:"olderThan"`
+	Protect           string   `json:"protect"`
+}
+
+// LoadPolicy reads an ordered list of rules from a YAML or JSON file.
+func LoadPolicy(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data)
+}
+
+func ParsePolicy(data []byte) ([]Rule, error) {
+	var pf policyFile
+	if err := yaml.UnmarshalStrict(data, &pf); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	if len(pf.Rules) == 0 {
+		return nil, fmt.Errorf("invalid policy: no rules")
+	}
+	out := make([]Rule, 0, len(pf.Rules))
+	for i, pr := range pf.Rules {
+		r := Rule{
+			Name:              pr.Name,
+			Namespaces:        pr.Namespaces,
+			ExcludeNamespaces: pr.ExcludeNamespaces,
+			LabelSelector:     pr.LabelSelector,
+			Kinds:             pr.Kinds,
+			States:            pr.States,
+		}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if pr.OlderThan != "" {
+			d, err := time.ParseDuration(pr.OlderThan)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid olderThan: %w", r.Name, err)
+			}
+			r.OlderThan = d
+		}
+		r.ProtectKey, r.ProtectVal = helpers.ParseKV(pr.Protect)
+		if _, err := labels.Parse(r.LabelSelector); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid labelSelector: %w", r.Name, err)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+type compiledRule struct {
+	Rule
+	kinds    map[string]bool
+	selector labels.Selector
+}
+
+// compileRules resolves rule kinds and selectors. Without configured rules the
+// Config itself acts as a single unnamed rule.
+func (e *Engine) compileRules() ([]compiledRule, error) {
+	rules := e.cfg.Rules
+	if len(rules) == 0 {
+		rules = []Rule{{Kinds: e.cfg.Kinds}}
+	}
+	out := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		if r.OlderThan == 0 {
+			r.OlderThan = e.cfg.OlderThan
+		}
+		if r.ProtectKey == "" {
+			r.ProtectKey, r.ProtectVal = e.cfg.ProtectKey, e.cfg.ProtectVal
+		}
+		sel, err := labels.Parse(r.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		cr := compiledRule{Rule: r, selector: sel}
+		if len(r.Kinds) > 0 {
+			cr.kinds = map[string]bool{}
+			for _, name := range r.Kinds {
+				k, err := e.resolveKind(name)
+				if err != nil {
+					return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+				}
+				cr.kinds[k.Name] = true
+			}
+		}
+		out = append(out, cr)
+	}
+	return out, nil
+}
+
+// Kinds returns the names of the kinds a scan lists, from Config.Kinds and
+// the policy rules.
+func (e *Engine) Kinds() ([]string, error) {
+	rules, err := e.compileRules()
+	if err != nil {
+		return nil, err
+	}
+	kinds, err := e.kindsFor(rules)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = k.Name
+	}
+	return names, nil
+}
+
+// kindsFor returns the kinds any rule needs listed, in first-seen order. Rules
+// without kinds apply to Config.Kinds.
+func (e *Engine) kindsFor(rules []compiledRule) ([]Kind, error) {
+	var names []string
+	for _, r := range rules {
+		if len(r.Kinds) == 0 {
+			names = append(names, e.cfg.Kinds...)
+		}
+		names = append(names, r.Kinds...)
+	}
+	seen := map[string]bool{}
+	var out []Kind
+	for _, name := range names {
+		k, err := e.resolveKind(name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[k.Name] {
+			continue
+		}
+		seen[k.Name] = true
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+func (r *compiledRule) matches(k Kind, it Item) bool {
+	if r.kinds != nil && !r.kinds[k.Name] {
+		return false
+	}
+	ns := it.Object.GetNamespace()
+	if len(r.Namespaces) > 0 && !matchAny(r.Namespaces, ns) {
+		return false
+	}
+	if matchAny(r.ExcludeNamespaces, ns) {
+		return false
+	}
+	return r.selector.Matches(labels.Set(it.Object.GetLabels()))
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) ruleStateIncluded(r *compiledRule, k Kind, state string) bool {
+	if len(r.States) == 0 {
+		return e.stateIncluded(k, state)
+	}
+	for _, s := range r.States {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testPolicy = `
+rules:
+- name: ci-fast
+  namespaces: ["ci-*"]
+  kinds: [pod]
+  states: [Succeeded, Failed]
+  olderThan: 1h
+- name: keep-db
+  labelSelector: app=db
+  states: []
+  olderThan: 10000h
+- name: default
+  olderThan: 24h
+  protect: pinned
+`
+
+func Test_ParsePolicy(t *testing.T) {
+	rules, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 || rules[0].OlderThan != time.Hour || rules[2].ProtectKey != "pinned" {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+	if _, err := ParsePolicy([]byte("rules:\n- olderThan: soon\n")); err == nil {
+		t.Fatal("want error for invalid olderThan")
+	}
+	if _, err := ParsePolicy([]byte("rules:\n- olderThen: 1h\n")); err == nil {
+		t.Fatal("want error for unknown field")
+	}
+}
+
+func Test_FindCandidates_PolicyFirstMatchWins(t *testing.T) {
+	rules, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewSimpleClientset(
+		ns("ci-1"), ns("prod"),
+		pod("ci-1", "ci-old", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil),
+		pod("ci-1", "ci-new", corev1.PodSucceeded, "", time.Now().Add(-30*time.Minute), nil),
+		pod("prod", "db", corev1.PodFailed, "", time.Now().Add(-48*time.Hour), map[string]string{"app": "db"}),
+		pod("prod", "web-young", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil),
+		pod("prod", "web-old", corev1.PodSucceeded, "", time.Now().Add(-48*time.Hour), nil),
+		pod("prod", "web-pinned", corev1.PodSucceeded, "", time.Now().Add(-48*time.Hour), map[string]string{"pinned": "yes"}),
+	)
+	cfg := Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod"},
+		AllNamespaces:    true,
+		IncludeCompleted: true,
+		IncludeFailed:    true,
+		Rules:            rules,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, cand := range list {
+		got[cand.Name] = cand.Rule
+	}
+	want := map[string]string{"ci-old": "ci-fast", "web-old": "default"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for name, rule := range want {
+		if got[name] != rule {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const kubeRootCA = "kube-root-ca.crt"
+
+// Secret types that are managed by controllers or tools and must never be
+// treated as orphans even when nothing mounts them.
+var systemSecretTypes = map[corev1.SecretType]bool{
+	corev1.SecretTypeServiceAccountToken: true,
+	corev1.SecretTypeBootstrapToken:      true,
+	"helm.sh/release.v1":                 true,
+}
+
+type refGraph struct {
+	configMaps map[string]struct{}
+	secrets    map[string]struct{}
+}
+
+func (g *refGraph) addConfigMap(name string) {
+	if name != "" {
+		g.configMaps[name] = struct{}{}
+	}
+}
+
+func (g *refGraph) addSecret(name string) {
+	if name != "" {
+		g.secrets[name] = struct{}{}
+	}
+}
+
+// refsFor returns the ConfigMaps and Secrets referenced in ns, building the
+// graph once per namespace per FindCandidates call. The lists run without
+// holding e.mu, so namespaces are built concurrently.
+func (e *Engine) refsFor(ctx context.Context, ns string) (*refGraph, error) {
+	e.mu.Lock()
+	g, ok := e.refs[ns]
+	e.mu.Unlock()
+	if ok {
+		return g, nil
+	}
+	g, err := buildRefGraph(ctx, e, ns)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if built, ok := e.refs[ns]; ok {
+		return built, nil
+	}
+	if e.refs == nil {
+		e.refs = map[string]*refGraph{}
+	}
+	e.refs[ns] = g
+	return g, nil
+}
+
+func buildRefGraph(ctx context.Context, e *Engine, ns string) (*refGraph, error) {
+	g := &refGraph{configMaps: map[string]struct{}{}, secrets: map[string]struct{}{}}
+	all := metav1.ListOptions{}
+
+	pods, err := e.kube.CoreV1().Pods(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		g.addPodSpec(&pods.Items[i].Spec)
+	}
+	deps, err := e.kube.AppsV1().Deployments(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deps.Items {
+		g.addPodSpec(&deps.Items[i].Spec.Template.Spec)
+	}
+	// ReplicaSets kept for rollback still reference what their template
+	// mounts, even when scaled to zero.
+	rss, err := e.kube.AppsV1().ReplicaSets(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rss.Items {
+		g.addPodSpec(&rss.Items[i].Spec.Template.Spec)
+	}
+	sts, err := e.kube.AppsV1().StatefulSets(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sts.Items {
+		g.addPodSpec(&sts.Items[i].Spec.Template.Spec)
+	}
+	dss, err := e.kube.AppsV1().DaemonSets(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dss.Items {
+		g.addPodSpec(&dss.Items[i].Spec.Template.Spec)
+	}
+	jobs, err := e.kube.BatchV1().Jobs(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		g.addPodSpec(&jobs.Items[i].Spec.Template.Spec)
+	}
+	cjs, err := e.kube.BatchV1().CronJobs(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cjs.Items {
+		g.addPodSpec(&cjs.Items[i].Spec.JobTemplate.Spec.Template.Spec)
+	}
+	sas, err := e.kube.CoreV1().ServiceAccounts(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for _, sa := range sas.Items {
+		for _, s := range sa.Secrets {
+			g.addSecret(s.Name)
+		}
+		for _, s := range sa.ImagePullSecrets {
+			g.addSecret(s.Name)
+		}
+	}
+	ings, err := e.kube.NetworkingV1().Ingresses(ns).List(ctx, all)
+	if err != nil {
+		return nil, err
+	}
+	for _, ing := range ings.Items {
+		for _, tls := range ing.Spec.TLS {
+			g.addSecret(tls.SecretName)
+		}
+	}
+	return g, nil
+}
+
+func (g *refGraph) addPodSpec(spec *corev1.PodSpec) {
+	for _, s := range spec.ImagePullSecrets {
+		g.addSecret(s.Name)
+	}
+	for _, v := range spec.Volumes {
+		if v.ConfigMap != nil {
+			g.addConfigMap(v.ConfigMap.Name)
+		}
+		if v.Secret != nil {
+			g.addSecret(v.Secret.SecretName)
+		}
+		if v.Projected != nil {
+			for _, src := range v.Projected.Sources {
+				if src.ConfigMap != nil {
+					g.addConfigMap(src.ConfigMap.Name)
+				}
+				if src.Secret != nil {
+					g.addSecret(src.Secret.Name)
+				}
+			}
+		}
+	}
+	for i := range spec.InitContainers {
+		g.addContainer(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		g.addContainer(&spec.Containers[i])
+	}
+	for i := range spec.EphemeralContainers {
+		c := corev1.Container(spec.EphemeralContainers[i].EphemeralContainerCommon)
+		g.addContainer(&c)
+	}
+}
+
+func (g *refGraph) addContainer(c *corev1.Container) {
+	for _, src := range c.EnvFrom {
+		if src.ConfigMapRef != nil {
+			g.addConfigMap(src.ConfigMapRef.Name)
+		}
+		if src.SecretRef != nil {
+			g.addSecret(src.SecretRef.Name)
+		}
+	}
+	for _, env := range c.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if env.ValueFrom.ConfigMapKeyRef != nil {
+			g.addConfigMap(env.ValueFrom.ConfigMapKeyRef.Name)
+		}
+		if env.ValueFrom.SecretKeyRef != nil {
+			g.addSecret(env.ValueFrom.SecretKeyRef.Name)
+		}
+	}
+}
+
+func refState(referenced bool, obj metav1.Object) string {
+	switch {
+	case referenced:
+		return "Referenced"
+	case len(obj.GetOwnerReferences()) > 0:
+		return "Owned"
+	default:
+		return "Unreferenced"
+	}
+}
+
+func configMapKind() Kind {
+	return Kind{
+		Name:    "configmap",
+		Aliases: []string{"cm"},
+		Selects: []string{"Unreferenced"},
+		List: func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error) {
+			list, err := e.kube.CoreV1().ConfigMaps(ns).List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			g, err := e.refsFor(ctx, ns)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]Item, 0, len(list.Items))
+			for i := range list.Items {
+				cm := &list.Items[i]
+				_, used := g.configMaps[cm.Name]
+				state := refState(used, cm)
+				if cm.Name == kubeRootCA {
+					state = "System"
+				}
+				out = append(out, Item{Object: cm, State: state, RefTime: cm.CreationTimestamp.Time})
+			}
+			return out, nil
+		},
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			return e.kube.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			return e.kube.CoreV1().ConfigMaps(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.CoreV1().ConfigMaps(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+	}
+}
+
+func secretKind() Kind {
+	return Kind{
+		Name:    "secret",
+		Selects: []string{"Unreferenced"},
+		List: func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error) {
+			list, err := e.kube.CoreV1().Secrets(ns).List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			g, err := e.refsFor(ctx, ns)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]Item, 0, len(list.Items))
+			for i := range list.Items {
+				s := &list.Items[i]
+				_, used := g.secrets[s.Name]
+				state := refState(used, s)
+				if systemSecretTypes[s.Type] {
+					state = "System"
+				}
+				out = append(out, Item{Object: s, State: state, RefTime: s.CreationTimestamp.Time})
+			}
+			return out, nil
+		},
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			return e.kube.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			return e.kube.CoreV1().Secrets(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.CoreV1().Secrets(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+	}
+}
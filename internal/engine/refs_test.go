@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func objMeta(ns, name string) meta.ObjectMeta {
+	return meta.ObjectMeta{Name: name, Namespace: ns, CreationTimestamp: meta.NewTime(time.Now().Add(-48 * time.Hour))}
+}
+
+func Test_FindCandidates_UnreferencedConfigMapsAndSecrets(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		&corev1.ConfigMap{ObjectMeta: objMeta("test", "cm-volume")},
+		&corev1.ConfigMap{ObjectMeta: objMeta("test", "cm-envfrom")},
+		&corev1.ConfigMap{ObjectMeta: objMeta("test", "cm-cronjob")},
+		&corev1.ConfigMap{ObjectMeta: objMeta("test", "cm-rollback")},
+		&corev1.ConfigMap{ObjectMeta: objMeta("test", "cm-orphan")},
+		&corev1.ConfigMap{ObjectMeta: objMeta("test", kubeRootCA)},
+		&corev1.Secret{ObjectMeta: objMeta("test", "s-env")},
+		&corev1.Secret{ObjectMeta: objMeta("test", "s-pull")},
+		&corev1.Secret{ObjectMeta: objMeta("test", "s-tls")},
+		&corev1.Secret{ObjectMeta: objMeta("test", "s-orphan")},
+		&corev1.Secret{ObjectMeta: objMeta("test", "s-token"), Type: corev1.SecretTypeServiceAccountToken},
+		&corev1.Pod{ObjectMeta: objMeta("test", "p"), Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm-volume"}},
+			}}},
+		}},
+		&appsv1.Deployment{ObjectMeta: objMeta("test", "d"), Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm-envfrom"}}}},
+				Env: []corev1.EnvVar{{Name: "X", ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "s-env"}, Key: "k"},
+				}}},
+			}},
+		}}}},
+		&appsv1.ReplicaSet{ObjectMeta: objMeta("test", "d-old"), Spec: appsv1.ReplicaSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "cm-rollback"}},
+			}}},
+		}}}},
+		&batchv1.CronJob{ObjectMeta: objMeta("test", "cj"), Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{{Name: "v", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "cm-cronjob"}}}},
+				}}}},
+			}},
+		}}}},
+		&corev1.ServiceAccount{ObjectMeta: objMeta("test", "sa"), ImagePullSecrets: []corev1.LocalObjectReference{{Name: "s-pull"}}},
+		&networkingv1.Ingress{ObjectMeta: objMeta("test", "ing"), Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{SecretName: "s-tls"}},
+		}},
+	)
+	cfg := Config{
+		OlderThan:  24 * time.Hour,
+		Kinds:      []string{"configmap", "secret"},
+		Namespaces: []string{"test"},
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cand := range list {
+		if cand.State != "Unreferenced" {
+			t.Fatalf("unexpected state %q for %s", cand.State, cand.Name)
+		}
+		names = append(names, cand.Kind+"/"+cand.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "configmap/cm-orphan" || names[1] != "secret/s-orphan" {
+		t.Fatalf("unexpected candidates: %v", names)
+	}
+}
+
+func Test_RefsFor_ListsWithoutHoldingTheLock(t *testing.T) {
+	c := fake.NewSimpleClientset(ns("test"))
+	e := New(c, Config{})
+	c.PrependReactor("list", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		done := make(chan struct{})
+		go func() {
+			e.Listed()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("e.mu is held while the reference graph is listed")
+		}
+		return false, nil, nil
+	})
+	if _, err := e.refsFor(context.Background(), "test"); err != nil {
+		t.Fatal(err)
+	}
+}
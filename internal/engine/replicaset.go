@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"sort"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// replicaSetKind selects ReplicaSets left behind by Deployment rollouts: owned
+// by a Deployment, scaled to zero and not the Deployment's current revision.
+// The newest Config.KeepRevisions of those are kept per Deployment, ranked
+// among all of its ReplicaSets whether or not the selectors match them.
+func replicaSetKind() Kind {
+	return Kind{
+		Name:    "replicaset",
+		Aliases: []string{"rs"},
+		Selects: []string{"Superseded"},
+		List: func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error) {
+			all := opts
+			all.LabelSelector, all.FieldSelector = "", ""
+			list, err := e.kube.AppsV1().ReplicaSets(ns).List(ctx, all)
+			if err != nil {
+				return nil, err
+			}
+			deps, err := e.kube.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+			current := make(map[types.UID]int64, len(deps.Items))
+			for i := range deps.Items {
+				current[deps.Items[i].UID] = helpers.Revision(deps.Items[i].Annotations)
+			}
+
+			old := map[types.UID][]*appsv1.ReplicaSet{}
+			ranked := make([]Item, 0, len(list.Items))
+			for i := range list.Items {
+				rs := &list.Items[i]
+				ref := metav1.GetControllerOf(rs)
+				if ref == nil || ref.Kind != "Deployment" {
+					ranked = append(ranked, Item{Object: rs, State: "Active", RefTime: rs.CreationTimestamp.Time})
+					continue
+				}
+				rev, known := current[ref.UID]
+				if !known || rev == 0 || helpers.Revision(rs.Annotations) == rev || !scaledToZero(rs) {
+					ranked = append(ranked, Item{Object: rs, State: "Active", RefTime: rs.CreationTimestamp.Time})
+					continue
+				}
+				old[ref.UID] = append(old[ref.UID], rs)
+			}
+
+			for _, group := range old {
+				sort.Slice(group, func(i, j int) bool {
+					return helpers.Revision(group[i].Annotations) > helpers.Revision(group[j].Annotations)
+				})
+				for i, rs := range group {
+					state := "Superseded"
+					if i < e.cfg.KeepRevisions {
+						state = "Retained"
+					}
+					ranked = append(ranked, Item{Object: rs, State: state, RefTime: rs.CreationTimestamp.Time})
+				}
+			}
+
+			// The selectors were left off the list so that ranking sees every
+			// revision; apply them here.
+			out := ranked[:0]
+			for _, it := range ranked {
+				labelOK, fieldOK, err := e.selected(it)
+				if err != nil {
+					return nil, err
+				}
+				if labelOK && fieldOK {
+					out = append(out, it)
+				}
+			}
+			return out, nil
+		},
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			return e.kube.AppsV1().ReplicaSets(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			return e.kube.AppsV1().ReplicaSets(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.AppsV1().ReplicaSets(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+	}
+}
+
+func scaledToZero(rs *appsv1.ReplicaSet) bool {
+	if rs.Spec.Replicas == nil || *rs.Spec.Replicas != 0 {
+		return false
+	}
+	return rs.Status.Replicas == 0
+}
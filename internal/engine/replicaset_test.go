@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func deployment(ns, name string, revision int) *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: meta.ObjectMeta{
+		Name: name, Namespace: ns, UID: types.UID("uid-" + name),
+		Annotations: map[string]string{helpers.RevisionAnnotation: strconv.Itoa(revision)},
+	}}
+}
+
+func replicaSet(ns, name, owner string, revision int, replicas int32, created time.Time) *appsv1.ReplicaSet {
+	controller := true
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: meta.ObjectMeta{
+			Name: name, Namespace: ns,
+			CreationTimestamp: meta.NewTime(created),
+			Annotations:       map[string]string{helpers.RevisionAnnotation: strconv.Itoa(revision)},
+		},
+		Spec:   appsv1.ReplicaSetSpec{Replicas: &replicas},
+		Status: appsv1.ReplicaSetStatus{Replicas: replicas},
+	}
+	if owner != "" {
+		rs.OwnerReferences = []meta.OwnerReference{{Kind: "Deployment", Name: owner, UID: types.UID("uid-" + owner), Controller: &controller}}
+	}
+	return rs
+}
+
+func Test_FindCandidates_ReplicaSets(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		deployment("test", "web", 5),
+		replicaSet("test", "web-1", "web", 1, 0, old),
+		replicaSet("test", "web-2", "web", 2, 0, old),
+		replicaSet("test", "web-3", "web", 3, 0, old),
+		replicaSet("test", "web-4", "web", 4, 2, old),
+		replicaSet("test", "web-5", "web", 5, 0, old),
+		replicaSet("test", "bare", "", 1, 0, old),
+		replicaSet("test", "gone-1", "gone", 1, 0, old),
+	)
+	cfg := Config{
+		OlderThan:     24 * time.Hour,
+		Kinds:         []string{"rs"},
+		Namespaces:    []string{"test"},
+		KeepRevisions: 1,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cand := range list {
+		names = append(names, cand.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "web-1" || names[1] != "web-2" {
+		t.Fatalf("unexpected candidates: %v", names)
+	}
+}
+
+func Test_FindCandidates_ReplicaSetsRankedRegardlessOfSelector(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	web1, web2 := replicaSet("test", "web-1", "web", 1, 0, old), replicaSet("test", "web-2", "web", 2, 0, old)
+	web1.Labels = map[string]string{"tier": "old"}
+	web2.Labels = map[string]string{"tier": "old"}
+	// Left over from an earlier Deployment of the same name.
+	stale := replicaSet("test", "web-0", "web", 9, 0, old)
+	stale.OwnerReferences[0].UID = "uid-deleted"
+	stale.Labels = map[string]string{"tier": "old"}
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		deployment("test", "web", 4),
+		web1, web2, stale,
+		replicaSet("test", "web-3", "web", 3, 0, old),
+		replicaSet("test", "web-4", "web", 4, 1, old),
+	)
+	cfg := Config{
+		OlderThan:     24 * time.Hour,
+		Kinds:         []string{"rs"},
+		Namespaces:    []string{"test"},
+		LabelSelector: "tier=old",
+		KeepRevisions: 1,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, cand := range list {
+		names = append(names, cand.Name)
+	}
+	sort.Strings(names)
+	// web-3 is the revision kept, though the selector leaves it out.
+	if len(names) != 2 || names[0] != "web-1" || names[1] != "web-2" {
+		t.Fatalf("unexpected candidates: %v", names)
+	}
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+)
+
+// keepLast returns the indexes of items preserved by Config.KeepLast: the N
+// most recent completed and N most recent failed items of every owner group.
+// Items are grouped by controlling owner, or by the Config.KeepLastLabel value
+// when they have none; items in neither group are not retained.
+func (e *Engine) keepLast(items []Item) map[int]bool {
+	if e.cfg.KeepLast <= 0 {
+		return nil
+	}
+	groups := map[string][]int{}
+	for i, it := range items {
+		class := retentionClass(it.State)
+		if class == "" {
+			continue
+		}
+		owner := ownerGroup(it, e.cfg.KeepLastLabel)
+		if owner == "" {
+			continue
+		}
+		key := it.Object.GetNamespace() + "/" + owner + "/" + class
+		groups[key] = append(groups[key], i)
+	}
+	kept := map[int]bool{}
+	for _, idx := range groups {
+		sort.SliceStable(idx, func(a, b int) bool {
+			return items[idx[a]].RefTime.After(items[idx[b]].RefTime)
+		})
+		for n, i := range idx {
+			if n >= e.cfg.KeepLast {
+				break
+			}
+			kept[i] = true
+		}
+	}
+	return kept
+}
+
+func retentionClass(state string) string {
+	switch strings.ToLower(state) {
+	case "succeeded":
+		return "completed"
+	case "failed", "evicted":
+		return "failed"
+	default:
+		return ""
+	}
+}
+
+func ownerGroup(it Item, label string) string {
+	for _, ref := range it.Object.GetOwnerReferences() {
+		if ref.Controller != nil && *ref.Controller {
+			return ref.Kind + "/" + ref.Name
+		}
+	}
+	if label == "" {
+		return ""
+	}
+	if v, ok := it.Object.GetLabels()[label]; ok && v != "" {
+		return "label/" + label + "=" + v
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_FindCandidates_KeepLast(t *testing.T) {
+	controller := true
+	owned := func(name, state string, age time.Duration) *batchv1.Job {
+		j := job("test", name, state, time.Now().Add(-age), nil)
+		j.OwnerReferences = []meta.OwnerReference{{Kind: "CronJob", Name: "nightly", Controller: &controller}}
+		return j
+	}
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		owned("n-1", "Succeeded", 50*time.Hour),
+		owned("n-2", "Succeeded", 40*time.Hour),
+		owned("n-3", "Succeeded", 30*time.Hour),
+		owned("n-4", "Failed", 45*time.Hour),
+		owned("n-5", "Failed", 35*time.Hour),
+		job("test", "labelled-1", "Succeeded", time.Now().Add(-50*time.Hour), map[string]string{"app": "x"}),
+		job("test", "labelled-2", "Succeeded", time.Now().Add(-40*time.Hour), map[string]string{"app": "x"}),
+		job("test", "adhoc", "Succeeded", time.Now().Add(-40*time.Hour), nil),
+	)
+	cfg := Config{
+		OlderThan:        24 * time.Hour,
+		Kinds:            []string{"job"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+		IncludeFailed:    true,
+		KeepLast:         1,
+		KeepLastLabel:    "app",
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := candidateNames(list)
+	want := []string{"job/adhoc", "job/labelled-1", "job/n-1", "job/n-2", "job/n-4"}
+	if len(names) != len(want) {
+		t.Fatalf("want %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, names)
+		}
+	}
+}
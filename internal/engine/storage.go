@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type claimUsage struct {
+	inUse    bool
+	lastUsed time.Time
+}
+
+// claimUsageFor reports, per claim name, whether a non-terminal pod mounts it
+// and when the last terminated pod mounting it finished.
+func claimUsageFor(ctx context.Context, e *Engine, ns string) (map[string]claimUsage, error) {
+	pods, err := e.kube.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]claimUsage{}
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		terminal := p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed
+		for _, v := range p.Spec.Volumes {
+			if v.PersistentVolumeClaim == nil {
+				continue
+			}
+			u := out[v.PersistentVolumeClaim.ClaimName]
+			if !terminal {
+				u.inUse = true
+			} else if t := podFinishTime(p); t.After(u.lastUsed) {
+				u.lastUsed = t
+			}
+			out[v.PersistentVolumeClaim.ClaimName] = u
+		}
+	}
+	return out, nil
+}
+
+// statefulSetClaims reports whether a claim belongs to a StatefulSet: it is
+// owned by one, or named <template>-<statefulset>-<ordinal> after a claim
+// template of one that exists. Such claims are kept while the StatefulSet is
+// scaled to zero, so they are in use even when no pod mounts them.
+func statefulSetClaims(ctx context.Context, e *Engine, ns string) (func(*corev1.PersistentVolumeClaim) bool, error) {
+	list, err := e.kube.AppsV1().StatefulSets(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []string
+	for _, sts := range list.Items {
+		for _, t := range sts.Spec.VolumeClaimTemplates {
+			prefixes = append(prefixes, t.Name+"-"+sts.Name+"-")
+		}
+	}
+	return func(pvc *corev1.PersistentVolumeClaim) bool {
+		for _, ref := range pvc.OwnerReferences {
+			if ref.Kind == "StatefulSet" {
+				return true
+			}
+		}
+		for _, p := range prefixes {
+			if ord, ok := strings.CutPrefix(pvc.Name, p); ok {
+				if _, err := strconv.ParseUint(ord, 10, 32); err == nil {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+func podFinishTime(p *corev1.Pod) time.Time {
+	var t time.Time
+	for _, cs := range p.Status.ContainerStatuses {
+		if cs.State.Terminated != nil && cs.State.Terminated.FinishedAt.After(t) {
+			t = cs.State.Terminated.FinishedAt.Time
+		}
+	}
+	if t.IsZero() && p.Status.StartTime != nil {
+		t = p.Status.StartTime.Time
+	}
+	if t.IsZero() {
+		t = p.CreationTimestamp.Time
+	}
+	return t
+}
+
+// pvcKind selects claims that are Pending or Lost past --older-than, and bound
+// claims no pod has mounted for Config.PVCIdle. Claims mounted by a running
+// pod, and with Config.PVCIdle those of a StatefulSet, are never selected.
+func pvcKind() Kind {
+	return Kind{
+		Name:    "pvc",
+		Aliases: []string{"persistentvolumeclaim"},
+		Selects: []string{"Pending", "Lost", "Idle"},
+		List: func(ctx context.Context, e *Engine, ns string, opts metav1.ListOptions) ([]Item, error) {
+			list, err := e.kube.CoreV1().PersistentVolumeClaims(ns).List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			usage, err := claimUsageFor(ctx, e, ns)
+			if err != nil {
+				return nil, err
+			}
+			ofStatefulSet := func(*corev1.PersistentVolumeClaim) bool { return false }
+			if e.cfg.PVCIdle > 0 {
+				if ofStatefulSet, err = statefulSetClaims(ctx, e, ns); err != nil {
+					return nil, err
+				}
+			}
+			out := make([]Item, 0, len(list.Items))
+			for i := range list.Items {
+				pvc := &list.Items[i]
+				it := Item{Object: pvc, State: string(pvc.Status.Phase), RefTime: pvc.CreationTimestamp.Time}
+				u := usage[pvc.Name]
+				switch {
+				case u.inUse, pvc.Status.Phase == corev1.ClaimBound && ofStatefulSet(pvc):
+					it.State = "InUse"
+				case pvc.Status.Phase == corev1.ClaimBound && e.cfg.PVCIdle > 0:
+					it.State = "Idle"
+					it.MaxAge = e.cfg.PVCIdle
+					if u.lastUsed.After(it.RefTime) {
+						it.RefTime = u.lastUsed
+					}
+				}
+				out = append(out, it)
+			}
+			return out, nil
+		},
+		Get: func(ctx context.Context, e *Engine, ns, name string) (runtime.Object, error) {
+			return e.kube.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, ns, name string, opts metav1.DeleteOptions) error {
+			return e.kube.CoreV1().PersistentVolumeClaims(ns).Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, ns, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.CoreV1().PersistentVolumeClaims(ns).Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+	}
+}
+
+// pvKind selects Released and Failed volumes. Volumes whose reclaim policy is
+// Retain are only selected with Config.DeleteRetainedPVs; bound volumes never.
+func pvKind() Kind {
+	return Kind{
+		Name:          "pv",
+		Aliases:       []string{"persistentvolume"},
+		ClusterScoped: true,
+		Selects:       []string{"Released"},
+		List: func(ctx context.Context, e *Engine, _ string, opts metav1.ListOptions) ([]Item, error) {
+			list, err := e.kube.CoreV1().PersistentVolumes().List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]Item, 0, len(list.Items))
+			for i := range list.Items {
+				pv := &list.Items[i]
+				state := string(pv.Status.Phase)
+				if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain && !e.cfg.DeleteRetainedPVs {
+					state = "Retained"
+				}
+				if pv.Status.Phase == corev1.VolumeBound || pv.Status.Phase == corev1.VolumeAvailable {
+					state = string(pv.Status.Phase)
+				}
+				ref := pv.CreationTimestamp.Time
+				if pv.Status.LastPhaseTransitionTime != nil {
+					ref = pv.Status.LastPhaseTransitionTime.Time
+				}
+				out = append(out, Item{Object: pv, State: state, RefTime: ref})
+			}
+			return out, nil
+		},
+		Get: func(ctx context.Context, e *Engine, _, name string) (runtime.Object, error) {
+			return e.kube.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+		},
+		Delete: func(ctx context.Context, e *Engine, _, name string, opts metav1.DeleteOptions) error {
+			return e.kube.CoreV1().PersistentVolumes().Delete(ctx, name, opts)
+		},
+		Patch: func(ctx context.Context, e *Engine, _, name string, pt types.PatchType, data []byte) error {
+			_, err := e.kube.CoreV1().PersistentVolumes().Patch(ctx, name, pt, data, metav1.PatchOptions{})
+			return err
+		},
+	}
+}
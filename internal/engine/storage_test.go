@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func pvc(ns, name string, phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{ObjectMeta: objMeta(ns, name), Status: corev1.PersistentVolumeClaimStatus{Phase: phase}}
+}
+
+func claimPod(ns, name, claim string, phase corev1.PodPhase, finished time.Time) *corev1.Pod {
+	p := &corev1.Pod{
+		ObjectMeta: objMeta(ns, name),
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+		}}}},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	if !finished.IsZero() {
+		p.Status.ContainerStatuses = []corev1.ContainerStatus{{State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{FinishedAt: meta.NewTime(finished)},
+		}}}
+	}
+	return p
+}
+
+func pv(name string, phase corev1.PersistentVolumePhase, policy corev1.PersistentVolumeReclaimPolicy) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: objMeta("", name),
+		Spec:       corev1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: policy},
+		Status:     corev1.PersistentVolumeStatus{Phase: phase},
+	}
+}
+
+func candidateNames(list []Candidate) []string {
+	var names []string
+	for _, c := range list {
+		names = append(names, c.Kind+"/"+c.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func Test_FindCandidates_PVCs(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		pvc("test", "pending", corev1.ClaimPending),
+		pvc("test", "lost", corev1.ClaimLost),
+		pvc("test", "in-use", corev1.ClaimBound),
+		pvc("test", "idle", corev1.ClaimBound),
+		pvc("test", "recently-used", corev1.ClaimBound),
+		claimPod("test", "runner", "in-use", corev1.PodRunning, time.Time{}),
+		claimPod("test", "done", "recently-used", corev1.PodSucceeded, time.Now().Add(-time.Hour)),
+	)
+	cfg := Config{
+		OlderThan:  24 * time.Hour,
+		Kinds:      []string{"pvc"},
+		Namespaces: []string{"test"},
+		PVCIdle:    12 * time.Hour,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := candidateNames(list)
+	if len(names) != 3 || names[0] != "pvc/idle" || names[1] != "pvc/lost" || names[2] != "pvc/pending" {
+		t.Fatalf("unexpected candidates: %v", names)
+	}
+
+	cfg.PVCIdle = 0
+	list, err = New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := candidateNames(list); len(names) != 2 {
+		t.Fatalf("idle claims selected without --pvc-idle: %v", names)
+	}
+}
+
+func Test_FindCandidates_PVCsOfScaledDownStatefulSet(t *testing.T) {
+	zero := int32(0)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: objMeta("test", "db"),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:             &zero,
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{ObjectMeta: meta.ObjectMeta{Name: "data"}}},
+		},
+	}
+	owned := pvc("test", "cache-0", corev1.ClaimBound)
+	owned.OwnerReferences = []meta.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "web", UID: "uid"}}
+	c := fake.NewSimpleClientset(
+		ns("test"), sts, owned,
+		pvc("test", "data-db-0", corev1.ClaimBound),
+		pvc("test", "data-db-1", corev1.ClaimBound),
+		pvc("test", "data-db-backup", corev1.ClaimBound),
+		pvc("test", "data-gone-0", corev1.ClaimBound),
+	)
+	cfg := Config{
+		OlderThan:  24 * time.Hour,
+		Kinds:      []string{"pvc"},
+		Namespaces: []string{"test"},
+		PVCIdle:    12 * time.Hour,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := candidateNames(list)
+	if len(names) != 2 || names[0] != "pvc/data-db-backup" || names[1] != "pvc/data-gone-0" {
+		t.Fatalf("claims of a StatefulSet selected as idle: %v", names)
+	}
+}
+
+func Test_FindCandidates_PVs(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		pv("released-delete", corev1.VolumeReleased, corev1.PersistentVolumeReclaimDelete),
+		pv("released-retain", corev1.VolumeReleased, corev1.PersistentVolumeReclaimRetain),
+		pv("failed", corev1.VolumeFailed, corev1.PersistentVolumeReclaimDelete),
+		pv("bound", corev1.VolumeBound, corev1.PersistentVolumeReclaimDelete),
+		pv("available", corev1.VolumeAvailable, corev1.PersistentVolumeReclaimRetain),
+	)
+	cfg := Config{
+		OlderThan:     24 * time.Hour,
+		Kinds:         []string{"pv"},
+		Namespaces:    []string{"test"},
+		IncludeFailed: true,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := candidateNames(list)
+	if len(names) != 2 || names[0] != "pv/failed" || names[1] != "pv/released-delete" {
+		t.Fatalf("unexpected candidates: %v", names)
+	}
+
+	cfg.DeleteRetainedPVs = true
+	list, err = New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := candidateNames(list); len(names) != 3 {
+		t.Fatalf("retained PV not selected with DeleteRetainedPVs: %v", names)
+	}
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// StateTerminating is the state of pods that have a deletionTimestamp.
+const StateTerminating = "Terminating"
+
+// ErrStillTerminating is returned by Delete for a Terminating pod when none of
+// the configured actions applies, e.g. force deletion is enabled but the node
+// is Ready.
+var ErrStillTerminating = errors.New("pod left terminating: no action applies")
+
+// SkipStillTerminating is the skip reason for ErrStillTerminating.
+const SkipStillTerminating = "still terminating"
+
+// unstick handles a pod stuck Terminating, where a plain delete does nothing.
+// It strips Config.StripFinalizers and, with Config.ForceDeleteTerminating,
+// deletes the pod with a zero grace period when its node is NotReady or gone,
+// since no kubelet will ever confirm that its containers stopped.
+func (e *Engine) unstick(ctx context.Context, k Kind, c Candidate) (DeleteResult, error) {
+	var res DeleteResult
+	if k.Name != "pod" {
+		return res, fmt.Errorf("kind %q cannot be handled while terminating", k.Name)
+	}
+	pod, err := e.kube.CoreV1().Pods(c.Namespace).Get(ctx, c.Name, metav1.GetOptions{})
+	if err != nil {
+		return res, err
+	}
+	if c.UID != "" && pod.UID != c.UID {
+		return res, ErrChanged
+	}
+	if keep, removed := splitFinalizers(pod.Finalizers, e.cfg.StripFinalizers); len(removed) > 0 {
+		if err := e.replaceFinalizers(ctx, pod, keep); err != nil {
+			return res, err
+		}
+		res.Actions = append(res.Actions, "strip-finalizers:"+strings.Join(removed, ","))
+	}
+	if e.cfg.ForceDeleteTerminating {
+		reason, err := e.nodeDown(ctx, pod.Spec.NodeName)
+		if err != nil {
+			return res, err
+		}
+		if reason != "" {
+			grace := int64(0)
+			uid := pod.UID
+			opts := metav1.DeleteOptions{GracePeriodSeconds: &grace, Preconditions: &metav1.Preconditions{UID: &uid}}
+			res.Calls, err = e.deleteWithRetry(ctx, k, c, opts)
+			res.Retries = max(res.Calls-1, 0)
+			switch {
+			case apierrors.IsNotFound(err) && len(res.Actions) > 0:
+				// Stripping the finalizers already let it go.
+			case err != nil:
+				return res, err
+			default:
+				res.Actions = append(res.Actions, "force-delete:"+reason)
+			}
+		}
+	}
+	if len(res.Actions) == 0 {
+		return res, ErrStillTerminating
+	}
+	return res, nil
+}
+
+func splitFinalizers(have, strip []string) (keep, removed []string) {
+	keep = []string{}
+	for _, f := range have {
+		if containsString(strip, f) {
+			removed = append(removed, f)
+		} else {
+			keep = append(keep, f)
+		}
+	}
+	return keep, removed
+}
+
+// replaceFinalizers sets the pod's finalizers to keep, failing if they changed
+// since the pod was read.
+func (e *Engine) replaceFinalizers(ctx context.Context, pod *corev1.Pod, keep []string) error {
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/finalizers", "value": pod.Finalizers},
+		{"op": "replace", "path": "/metadata/finalizers", "value": keep},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = e.kube.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// nodeDown returns why the node cannot finish the pod, or "" while it is Ready.
+func (e *Engine) nodeDown(ctx context.Context, name string) (string, error) {
+	if name == "" {
+		return "no node", nil
+	}
+	node, err := e.kube.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "node missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+			return "", nil
+		}
+	}
+	return "node NotReady", nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func terminatingPod(name, node string, since time.Duration, finalizers ...string) *corev1.Pod {
+	p := pod("test", name, corev1.PodRunning, "", time.Now().Add(-48*time.Hour), nil)
+	p.UID = types.UID("uid-" + name)
+	p.Spec.NodeName = node
+	p.Finalizers = finalizers
+	t := meta.NewTime(time.Now().Add(-since))
+	p.DeletionTimestamp = &t
+	return p
+}
+
+func node(name string, ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: meta.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}},
+	}
+}
+
+func Test_Terminating(t *testing.T) {
+	c := fake.NewSimpleClientset(ns("test"),
+		node("up", corev1.ConditionTrue), node("down", corev1.ConditionUnknown),
+		terminatingPod("on-down-node", "down", 2*time.Hour),
+		terminatingPod("on-missing-node", "gone", 2*time.Hour),
+		terminatingPod("on-ready-node", "up", 2*time.Hour),
+		terminatingPod("finalized", "up", 2*time.Hour, "example.com/block", "example.com/other"),
+		terminatingPod("recent", "down", 10*time.Minute),
+		pod("test", "running", corev1.PodRunning, "", time.Now().Add(-48*time.Hour), nil),
+	)
+	e := New(c, Config{
+		OlderThan:              24 * time.Hour,
+		Kinds:                  []string{"pod"},
+		Namespaces:             []string{"test"},
+		TerminatingAfter:       time.Hour,
+		ForceDeleteTerminating: true,
+		StripFinalizers:        []string{"example.com/block"},
+	})
+	ctx := context.Background()
+
+	cands, err := e.FindCandidates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Candidate{}
+	for _, cand := range cands {
+		got[cand.Name] = cand
+	}
+	if len(got) != 4 || got["recent"].Name != "" || got["running"].Name != "" {
+		t.Fatalf("candidates %v", got)
+	}
+	if cand := got["on-down-node"]; cand.State != StateTerminating || cand.TTLSource != TTLSourceTerminating || cand.Age > 3*time.Hour {
+		t.Fatalf("terminating pods are aged from their deletionTimestamp: %+v", cand)
+	}
+
+	want := map[string]string{
+		"on-down-node":    "force-delete:node NotReady",
+		"on-missing-node": "force-delete:node missing",
+		"finalized":       "strip-finalizers:example.com/block",
+	}
+	for name, action := range want {
+		res, err := e.DeleteDetailed(ctx, got[name])
+		if err != nil || strings.Join(res.Actions, " ") != action {
+			t.Errorf("%s: actions %v err %v, want %s", name, res.Actions, err, action)
+		}
+	}
+	if _, err := e.DeleteDetailed(ctx, got["on-ready-node"]); !errors.Is(err, ErrStillTerminating) {
+		t.Errorf("pod on a ready node must be left alone, got %v", err)
+	}
+
+	for _, name := range []string{"on-down-node", "on-missing-node"} {
+		if _, err := c.CoreV1().Pods("test").Get(ctx, name, meta.GetOptions{}); err == nil {
+			t.Errorf("%s not force deleted", name)
+		}
+	}
+	p, err := c.CoreV1().Pods("test").Get(ctx, "finalized", meta.GetOptions{})
+	if err != nil || strings.Join(p.Finalizers, ",") != "example.com/other" {
+		t.Fatalf("finalizers after strip: %v, %v", p, err)
+	}
+}
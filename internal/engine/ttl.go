@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	TTLAnnotation      = "k8s-cleanup.io/ttl"
+	ExpireAtAnnotation = "k8s-cleanup.io/expire-at"
+)
+
+const (
+	TTLSourceObject    = "object"
+	TTLSourceOwner     = "owner"
+	TTLSourceNamespace = "namespace"
+	TTLSourceKind      = "kind"
+	TTLSourceRule      = "rule"
+	TTLSourceConfig    = "config"
+	// TTLSourceTerminating marks pods aged from their deletionTimestamp
+	// against Config.TerminatingAfter.
+	TTLSourceTerminating = "terminating"
+)
+
+// ttl is the effective retention of one object: either a maximum age measured
+// from its reference time or an absolute expiry instant.
+type ttl struct {
+	maxAge   time.Duration
+	expireAt time.Time
+	source   string
+}
+
+func (t ttl) due(ref time.Time) time.Time {
+	if !t.expireAt.IsZero() {
+		return t.expireAt
+	}
+	return ref.Add(t.maxAge)
+}
+
+func ttlFromAnnotations(ann map[string]string, source string) (ttl, bool) {
+	if v := ann[ExpireAtAnnotation]; v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return ttl{expireAt: t, source: source}, true
+		}
+	}
+	if v := ann[TTLAnnotation]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return ttl{maxAge: d, source: source}, true
+		}
+	}
+	return ttl{}, false
+}
+
+// resolveTTL checks the object, its controlling owner and its namespace for
+// TTL annotations before falling back to the kind and the matched rule.
+func (e *Engine) resolveTTL(ctx context.Context, r *compiledRule, it Item) (ttl, error) {
+	// TTL annotations describe how long an object may live, not how long it
+	// may hang while being deleted.
+	if it.State == StateTerminating && e.cfg.TerminatingAfter > 0 {
+		return ttl{maxAge: e.cfg.TerminatingAfter, source: TTLSourceTerminating}, nil
+	}
+	if t, ok := ttlFromAnnotations(it.Object.GetAnnotations(), TTLSourceObject); ok {
+		return t, nil
+	}
+	ns := it.Object.GetNamespace()
+	if ref := metav1.GetControllerOfNoCopy(it.Object); ref != nil {
+		ann, err := e.ownerAnnotations(ctx, ns, ref)
+		if err != nil {
+			return ttl{}, err
+		}
+		if t, ok := ttlFromAnnotations(ann, TTLSourceOwner); ok {
+			return t, nil
+		}
+	}
+	if ns != "" {
+		ann, err := e.namespaceAnnotations(ctx, ns)
+		if err != nil {
+			return ttl{}, err
+		}
+		if t, ok := ttlFromAnnotations(ann, TTLSourceNamespace); ok {
+			return t, nil
+		}
+	}
+	if it.MaxAge > 0 {
+		return ttl{maxAge: it.MaxAge, source: TTLSourceKind}, nil
+	}
+	if r.Name != "" {
+		return ttl{maxAge: r.OlderThan, source: TTLSourceRule}, nil
+	}
+	return ttl{maxAge: r.OlderThan, source: TTLSourceConfig}, nil
+}
+
+// annotationCacheTTL bounds how long Evaluate reuses owner and namespace
+// annotations. FindCandidates starts every scan with an empty cache; Evaluate,
+// called by the controller for every informer event, keeps it so a busy
+// namespace does not cost an apiserver call per pod update.
+const annotationCacheTTL = time.Minute
+
+type cachedAnnotations struct {
+	ann     map[string]string
+	fetched time.Time
+}
+
+// cachedLookup returns the annotations cached under key, calling get when
+// there are none or they are older than annotationCacheTTL. Expiry uses real
+// time, like API latencies, not the engine clock.
+func (e *Engine) cachedLookup(cache *map[string]cachedAnnotations, key string, get func() (metav1.Object, error)) (map[string]string, error) {
+	e.mu.Lock()
+	c, ok := (*cache)[key]
+	e.mu.Unlock()
+	if ok && time.Since(c.fetched) < annotationCacheTTL {
+		return c.ann, nil
+	}
+	obj, err := get()
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	var ann map[string]string
+	if err == nil && obj != nil {
+		ann = obj.GetAnnotations()
+	}
+	e.mu.Lock()
+	if *cache == nil {
+		*cache = map[string]cachedAnnotations{}
+	}
+	(*cache)[key] = cachedAnnotations{ann: ann, fetched: time.Now()}
+	e.mu.Unlock()
+	return ann, nil
+}
+
+func (e *Engine) namespaceAnnotations(ctx context.Context, ns string) (map[string]string, error) {
+	return e.cachedLookup(&e.nsAnn, ns, func() (metav1.Object, error) {
+		return e.kube.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	})
+}
+
+// ownerAnnotations returns the annotations of the owner ref names. Owners are
+// listed once per kind and namespace and cached like namespaces, so a scan of
+// thousands of pods from as many Jobs costs one LIST, not a GET per Job.
+// Owners that do not exist have no annotations.
+func (e *Engine) ownerAnnotations(ctx context.Context, ns string, ref *metav1.OwnerReference) (map[string]string, error) {
+	key := ns + "/" + ref.APIVersion + "/" + ref.Kind
+	e.mu.Lock()
+	c, ok := e.ownerAnn[key]
+	e.mu.Unlock()
+	if !ok || time.Since(c.fetched) >= annotationCacheTTL {
+		owners, err := e.listOwners(ctx, ns, ref)
+		if apierrors.IsForbidden(err) {
+			// Some installs may only get, not list, custom owner kinds.
+			return e.cachedLookup(&e.ownerGets, key+"/"+ref.Name, func() (metav1.Object, error) {
+				return e.getOwner(ctx, ns, ref)
+			})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		c = cachedOwners{ann: make(map[string]map[string]string, len(owners)), fetched: time.Now()}
+		for _, o := range owners {
+			c.ann[o.GetName()] = o.GetAnnotations()
+		}
+		e.mu.Lock()
+		if e.ownerAnn == nil {
+			e.ownerAnn = map[string]cachedOwners{}
+		}
+		e.ownerAnn[key] = c
+		e.mu.Unlock()
+	}
+	return c.ann[ref.Name], nil
+}
+
+type cachedOwners struct {
+	ann     map[string]map[string]string
+	fetched time.Time
+}
+
+// listOwners lists the objects of ref's kind in ns. Kinds the engine cannot
+// resolve have none.
+func (e *Engine) listOwners(ctx context.Context, ns string, ref *metav1.OwnerReference) ([]metav1.Object, error) {
+	var out []metav1.Object
+	opts := metav1.ListOptions{}
+	switch ref.Kind {
+	case "CronJob":
+		l, err := e.kube.BatchV1().CronJobs(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range l.Items {
+			out = append(out, &l.Items[i])
+		}
+		return out, nil
+	case "Job":
+		l, err := e.kube.BatchV1().Jobs(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range l.Items {
+			out = append(out, &l.Items[i])
+		}
+		return out, nil
+	case "ReplicaSet":
+		l, err := e.kube.AppsV1().ReplicaSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range l.Items {
+			out = append(out, &l.Items[i])
+		}
+		return out, nil
+	case "Deployment":
+		l, err := e.kube.AppsV1().Deployments(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range l.Items {
+			out = append(out, &l.Items[i])
+		}
+		return out, nil
+	case "StatefulSet":
+		l, err := e.kube.AppsV1().StatefulSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range l.Items {
+			out = append(out, &l.Items[i])
+		}
+		return out, nil
+	case "DaemonSet":
+		l, err := e.kube.AppsV1().DaemonSets(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range l.Items {
+			out = append(out, &l.Items[i])
+		}
+		return out, nil
+	}
+	gvr, ok := e.ownerResource(ref)
+	if !ok {
+		return nil, nil
+	}
+	l, err := e.dyn.Resource(gvr).Namespace(ns).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range l.Items {
+		out = append(out, &l.Items[i])
+	}
+	return out, nil
+}
+
+// getOwner fetches a single owner of a custom kind.
+func (e *Engine) getOwner(ctx context.Context, ns string, ref *metav1.OwnerReference) (metav1.Object, error) {
+	gvr, ok := e.ownerResource(ref)
+	if !ok {
+		return nil, nil
+	}
+	return e.dyn.Resource(gvr).Namespace(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+}
+
+func (e *Engine) ownerResource(ref *metav1.OwnerReference) (schema.GroupVersionResource, bool) {
+	if e.dyn == nil || e.mapper == nil {
+		return schema.GroupVersionResource{}, false
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, false
+	}
+	mapping, err := e.mapper.RESTMapping(gv.WithKind(ref.Kind).GroupKind(), gv.Version)
+	if err != nil {
+		return schema.GroupVersionResource{}, false
+	}
+	return mapping.Resource, true
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_FindCandidates_TTLAnnotations(t *testing.T) {
+	controller := true
+	annotated := func(j *batchv1.Job, ann map[string]string) *batchv1.Job {
+		j.Annotations = ann
+		return j
+	}
+	owned := job("test", "owned", "Succeeded", time.Now().Add(-3*time.Hour), nil)
+	owned.OwnerReferences = []meta.OwnerReference{{Kind: "CronJob", Name: "hourly", Controller: &controller}}
+	namespace := ns("test")
+	namespace.Annotations = map[string]string{TTLAnnotation: "48h"}
+
+	c := fake.NewSimpleClientset(
+		namespace,
+		&batchv1.CronJob{ObjectMeta: meta.ObjectMeta{Name: "hourly", Namespace: "test", Annotations: map[string]string{TTLAnnotation: "2h"}}},
+		owned,
+		annotated(job("test", "short", "Succeeded", time.Now().Add(-2*time.Hour), nil), map[string]string{TTLAnnotation: "1h"}),
+		annotated(job("test", "expired", "Succeeded", time.Now().Add(-time.Minute), nil),
+			map[string]string{ExpireAtAnnotation: time.Now().Add(-time.Second).UTC().Format(time.RFC3339)}),
+		annotated(job("test", "not-yet", "Succeeded", time.Now().Add(-100*time.Hour), nil),
+			map[string]string{ExpireAtAnnotation: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}),
+		job("test", "ns-default-young", "Succeeded", time.Now().Add(-30*time.Hour), nil),
+		job("test", "ns-default-old", "Succeeded", time.Now().Add(-50*time.Hour), nil),
+	)
+	cfg := Config{
+		OlderThan:        24 * time.Hour,
+		Kinds:            []string{"job"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	}
+	list, err := New(c, cfg).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, cand := range list {
+		got[cand.Name] = cand.TTLSource
+	}
+	want := map[string]string{
+		"owned":          TTLSourceOwner,
+		"short":          TTLSourceObject,
+		"expired":        TTLSourceObject,
+		"ns-default-old": TTLSourceNamespace,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for name, src := range want {
+		if got[name] != src {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func Test_FindCandidates_TTLFallsBackToConfig(t *testing.T) {
+	c := fake.NewSimpleClientset(
+		ns("test"),
+		pod("test", "p", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil),
+	)
+	list, err := New(c, Config{
+		OlderThan:        time.Hour,
+		Kinds:            []string{"pod"},
+		Namespaces:       []string{"test"},
+		IncludeCompleted: true,
+	}).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].TTLSource != TTLSourceConfig {
+		t.Fatalf("unexpected candidates: %+v", list)
+	}
+}
+
+func Test_FindCandidates_ListsOwnersOnce(t *testing.T) {
+	objs := []runtime.Object{ns("test")}
+	yes := true
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("ci-%03d", i)
+		j := job("test", name, "Succeeded", time.Now().Add(-2*time.Hour), nil)
+		p := pod("test", name+"-x", corev1.PodSucceeded, "", time.Now().Add(-2*time.Hour), nil)
+		p.OwnerReferences = []meta.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: name, Controller: &yes}}
+		objs = append(objs, j, p)
+	}
+	c := fake.NewSimpleClientset(objs...)
+	calls := map[string]int{}
+	c.PrependReactor("*", "*", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		calls[a.GetVerb()+" "+a.GetResource().Resource]++
+		return false, nil, nil
+	})
+	list, err := New(c, Config{OlderThan: time.Hour, Kinds: []string{"pod"}, Namespaces: []string{"test"}, IncludeCompleted: true}).FindCandidates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 200 {
+		t.Fatalf("got %d candidates, want 200", len(list))
+	}
+	if calls["get jobs"] != 0 || calls["list jobs"] != 1 {
+		t.Fatalf("calls %v, want the jobs listed once and never fetched one by one", calls)
+	}
+}
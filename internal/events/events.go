@@ -0,0 +1,187 @@
+// Package events reports deletions as Kubernetes Events on the workload that
+// owned the deleted object, so its owners can find out with kubectl where it
+// went.
+package events
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	Component = "k8s-cleanup"
+
+	ReasonDeleted   = "CleanedUp"
+	ReasonFailed    = "CleanupFailed"
+	ReasonScheduled = "ScheduledForDeletion"
+)
+
+// maxOwners bounds the owner lookup cache of long-running controllers.
+const maxOwners = 4096
+
+// flushReason marks the event Close sends through the broadcaster to find out
+// when everything queued before it was handled. It is never written.
+const flushReason = "k8s-cleanup.io/flush"
+
+// Recorder emits one Event per deletion or failed deletion through a
+// record.EventBroadcaster. Events go to the CronJob or Deployment behind the
+// deleted object, to its direct controller otherwise, or to the namespace for
+// ownerless objects. The broadcaster's correlator rate limits each namespace
+// with its own token bucket, dropping events over it rather than delaying
+// deletions, and aggregates repeated events on one owner as kubelet's are.
+type Recorder struct {
+	kube      kubernetes.Interface
+	rec       record.EventRecorder
+	broadcast record.EventBroadcaster
+	flushed   chan struct{}
+
+	mu     sync.Mutex
+	owners map[string]*corev1.ObjectReference
+}
+
+// NewForCluster writes events to the API server in the background. A qps of
+// 0 disables the per-namespace limit. Close must be called to wait for events
+// still being written.
+func NewForCluster(kube kubernetes.Interface, qps float64, burst int) *Recorder {
+	if qps <= 0 {
+		qps = math.MaxFloat32
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	b := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		QPS:       float32(qps),
+		BurstSize: burst,
+		SpamKeyFunc: func(ev *corev1.Event) string {
+			return ev.Source.Component + "/" + ev.InvolvedObject.Namespace
+		},
+	})
+	r := &Recorder{
+		kube:      kube,
+		rec:       b.NewRecorder(scheme.Scheme, corev1.EventSource{Component: Component}),
+		broadcast: b,
+		flushed:   make(chan struct{}),
+		owners:    map[string]*corev1.ObjectReference{},
+	}
+	b.StartRecordingToSink(&flushSink{EventSink: &typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")}, flushed: r.flushed})
+	return r
+}
+
+// Close waits up to timeout for events emitted so far to be written, then
+// shuts the broadcaster down.
+func (r *Recorder) Close(timeout time.Duration) {
+	// Events are handled in order, so once the flush event reaches the sink
+	// everything before it was written or dropped.
+	r.rec.Event(&corev1.ObjectReference{Kind: "Namespace", APIVersion: "v1", Name: flushReason}, corev1.EventTypeNormal, flushReason, "flush")
+	select {
+	case <-r.flushed:
+	case <-time.After(timeout):
+		log.Warn().Msg("gave up waiting for events to be written")
+	}
+	r.broadcast.Shutdown()
+}
+
+// flushSink writes events to the API server, except the flush event, which
+// it reports instead.
+type flushSink struct {
+	record.EventSink
+	flushed chan struct{}
+	once    sync.Once
+}
+
+func (s *flushSink) Create(ev *corev1.Event) (*corev1.Event, error) {
+	if ev.Reason == flushReason {
+		s.once.Do(func() { close(s.flushed) })
+		return ev, nil
+	}
+	out, err := s.EventSink.Create(ev)
+	if err != nil {
+		log.Warn().Err(err).Str("ns", ev.Namespace).Str("object", ev.InvolvedObject.Kind+"/"+ev.InvolvedObject.Name).Msg("writing event failed")
+	}
+	return out, err
+}
+
+// Deleted reports that c was deleted.
+func (r *Recorder) Deleted(ctx context.Context, c engine.Candidate) {
+	r.emit(ctx, c, corev1.EventTypeNormal, ReasonDeleted, "Deleted %s %s (%s)", c.Kind, c.Name, details(c))
+}
+
+// Failed reports that deleting c failed with err.
+func (r *Recorder) Failed(ctx context.Context, c engine.Candidate, err string) {
+	r.emit(ctx, c, corev1.EventTypeWarning, ReasonFailed, "Failed to delete %s %s (%s): %s", c.Kind, c.Name, details(c), err)
+}
+
+// Scheduled reports that c was marked for deletion at at.
+func (r *Recorder) Scheduled(ctx context.Context, c engine.Candidate, at time.Time) {
+	r.emit(ctx, c, corev1.EventTypeNormal, ReasonScheduled, "Scheduled %s %s for deletion at %s (%s)", c.Kind, c.Name, at.UTC().Format(time.RFC3339), details(c))
+}
+
+func details(c engine.Candidate) string {
+	s := fmt.Sprintf("state %s, age %s", c.State, c.Age.Round(time.Second))
+	if c.Rule != "" {
+		s += ", rule " + c.Rule
+	}
+	return s
+}
+
+func (r *Recorder) emit(ctx context.Context, c engine.Candidate, eventType, reason, format string, args ...interface{}) {
+	// Cluster-scoped objects have no namespace to report to.
+	if c.Namespace == "" {
+		return
+	}
+	r.rec.Eventf(r.target(ctx, c), eventType, reason, format, args...)
+}
+
+// target resolves the object an event about c belongs on: a Job's CronJob, a
+// ReplicaSet's Deployment, the direct controller, or the namespace.
+func (r *Recorder) target(ctx context.Context, c engine.Candidate) *corev1.ObjectReference {
+	o := c.Owner
+	if o == nil {
+		// Filed in the namespace itself so users who can only list events
+		// there still see it.
+		return &corev1.ObjectReference{Kind: "Namespace", APIVersion: "v1", Name: c.Namespace, Namespace: c.Namespace}
+	}
+	key := c.Namespace + "/" + o.Kind + "/" + o.Name
+	r.mu.Lock()
+	ref, ok := r.owners[key]
+	r.mu.Unlock()
+	if ok {
+		return ref
+	}
+
+	ref = &corev1.ObjectReference{Kind: o.Kind, APIVersion: o.APIVersion, Name: o.Name, Namespace: c.Namespace, UID: o.UID}
+	var parent *metav1.OwnerReference
+	switch o.Kind {
+	case "Job":
+		if j, err := r.kube.BatchV1().Jobs(c.Namespace).Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+			parent = metav1.GetControllerOf(j)
+		}
+	case "ReplicaSet":
+		if rs, err := r.kube.AppsV1().ReplicaSets(c.Namespace).Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+			parent = metav1.GetControllerOf(rs)
+		}
+	}
+	if parent != nil && (parent.Kind == "CronJob" || parent.Kind == "Deployment") {
+		ref = &corev1.ObjectReference{Kind: parent.Kind, APIVersion: parent.APIVersion, Name: parent.Name, Namespace: c.Namespace, UID: parent.UID}
+	}
+
+	r.mu.Lock()
+	if len(r.owners) >= maxOwners {
+		r.owners = map[string]*corev1.ObjectReference{}
+	}
+	r.owners[key] = ref
+	r.mu.Unlock()
+	return ref
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onurbalmeida/k8s-cleanup/internal/engine"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func controllerRef(kind, name string) *metav1.OwnerReference {
+	yes := true
+	return &metav1.OwnerReference{Kind: kind, Name: name, Controller: &yes}
+}
+
+func Test_Recorder_Targets(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "ci", Name: "nightly-123", OwnerReferences: []metav1.OwnerReference{*controllerRef("CronJob", "nightly")}}}
+	kube := fake.NewSimpleClientset(job)
+	r := NewForCluster(kube, 0, 1)
+	defer r.Close(time.Second)
+
+	for _, tc := range []struct {
+		name string
+		c    engine.Candidate
+		want string
+	}{
+		{"pod of a cronjob", engine.Candidate{Kind: "pod", Namespace: "ci", Name: "nightly-123-x", Owner: controllerRef("Job", "nightly-123")}, "CronJob/nightly"},
+		{"job of a cronjob", engine.Candidate{Kind: "job", Namespace: "ci", Name: "nightly-123", Owner: controllerRef("CronJob", "nightly")}, "CronJob/nightly"},
+		{"pod of a deleted job", engine.Candidate{Kind: "pod", Namespace: "ci", Name: "gone-x", Owner: controllerRef("Job", "gone")}, "Job/gone"},
+		{"ownerless", engine.Candidate{Kind: "configmap", Namespace: "ci", Name: "cfg"}, "Namespace/ci"},
+	} {
+		ref := r.target(context.Background(), tc.c)
+		if got := ref.Kind + "/" + ref.Name; got != tc.want {
+			t.Errorf("%s: target %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// events lists the events written to ns, oldest first.
+func events(t *testing.T, kube *fake.Clientset, ns string) []corev1.Event {
+	t.Helper()
+	list, err := kube.CoreV1().Events(ns).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].FirstTimestamp.Before(&list.Items[j].FirstTimestamp) })
+	return list.Items
+}
+
+func Test_Recorder_RateLimitsPerNamespace(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	r := NewForCluster(kube, 0.001, 2)
+	c := engine.Candidate{Kind: "pod", Namespace: "a", State: "Succeeded", Age: 90 * time.Minute, Rule: "ci"}
+	for i := 0; i < 5; i++ {
+		c.Name = fmt.Sprintf("p%d", i)
+		r.Deleted(context.Background(), c)
+	}
+	c.Namespace, c.Name = "b", "p"
+	r.Failed(context.Background(), c, "forbidden")
+	r.Close(5 * time.Second)
+
+	a, b := events(t, kube, "a"), events(t, kube, "b")
+	if len(a) != 2 || len(b) != 1 {
+		t.Fatalf("%d and %d events, want 2 for namespace a and 1 for b", len(a), len(b))
+	}
+	if a[0].Type != corev1.EventTypeNormal || a[0].Reason != ReasonDeleted || a[0].Message != "Deleted pod p0 (state Succeeded, age 1h30m0s, rule ci)" {
+		t.Fatalf("event %s %s %q", a[0].Type, a[0].Reason, a[0].Message)
+	}
+	if a[0].Source.Component != Component || a[0].InvolvedObject.Kind != "Namespace" {
+		t.Fatalf("event source %v, object %v", a[0].Source, a[0].InvolvedObject)
+	}
+	if b[0].Type != corev1.EventTypeWarning || !strings.HasPrefix(b[0].Message, "Failed to delete pod p") || !strings.HasSuffix(b[0].Message, ": forbidden") {
+		t.Fatalf("event %s %q", b[0].Type, b[0].Message)
+	}
+}
+
+func Test_Recorder_CloseWritesPendingEvents(t *testing.T) {
+	kube := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci"}})
+	r := NewForCluster(kube, 0, 1)
+	for i := 0; i < 20; i++ {
+		r.Deleted(context.Background(), engine.Candidate{Kind: "pod", Namespace: "ci", Name: fmt.Sprintf("p%d", i)})
+	}
+	start := time.Now()
+	r.Close(5 * time.Second)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Close took %s", d)
+	}
+	// The correlator folds repeated events on one object into one aggregated
+	// event, but counts every occurrence; the flush event is never written.
+	list := events(t, kube, "ci")
+	total := int32(0)
+	for _, ev := range list {
+		if ev.Reason == flushReason {
+			t.Fatalf("flush event written: %+v", ev)
+		}
+		total += ev.Count
+	}
+	if total != 20 || len(list) >= 20 {
+		t.Fatalf("%d events counting %d, want 20 aggregated into fewer", len(list), total)
+	}
+	if all := events(t, kube, "default"); len(all) != 0 {
+		t.Fatalf("events in default: %+v", all)
+	}
+}
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"strconv"
 	"strings"
 	"time"
 
@@ -9,6 +10,9 @@ import (
 )
 
 func PodState(p *corev1.Pod) string {
+	if p.DeletionTimestamp != nil {
+		return "Terminating"
+	}
 	if strings.EqualFold(p.Status.Reason, "Evicted") {
 		return "Evicted"
 	}
@@ -33,3 +37,13 @@ func JobRefTime(j *batchv1.Job) time.Time {
 	}
 	return j.CreationTimestamp.Time
 }
+
+const RevisionAnnotation = "deployment.kubernetes.io/revision"
+
+func Revision(annotations map[string]string) int64 {
+	v, err := strconv.ParseInt(annotations[RevisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestPodState(t *testing.T) {
@@ -16,4 +17,9 @@ func TestPodState(t *testing.T) {
 	if s := PodState(&p); s != "Evicted" {
 		t.Fatal(s)
 	}
+	now := metav1.Now()
+	p.DeletionTimestamp = &now
+	if s := PodState(&p); s != "Terminating" {
+		t.Fatal(s)
+	}
 }
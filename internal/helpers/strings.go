@@ -2,15 +2,15 @@ package helpers
 
 import "strings"
 
-func normalizeKind(s string) string {
+func NormalizeKind(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
 	return strings.TrimSuffix(s, "s")
 }
 
 func HasKind(kinds []string, k string) bool {
-	nk := normalizeKind(k)
+	nk := NormalizeKind(k)
 	for _, x := range kinds {
-		if normalizeKind(x) == nk {
+		if NormalizeKind(x) == nk {
 			return true
 		}
 	}
@@ -0,0 +1,115 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// ErrHeld is returned when another instance holds the lease and Config.Wait
+// is false.
+var ErrHeld = errors.New("lease is held by another instance")
+
+// ErrLost is returned when the lease could not be renewed before fn finished.
+var ErrLost = errors.New("lease lost before the run finished")
+
+type Config struct {
+	Namespace     string
+	Name          string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// Wait blocks until the lease is free instead of returning ErrHeld.
+	Wait bool
+}
+
+// HeldError carries the identity of the instance holding the lease.
+type HeldError struct {
+	Holder string
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrHeld, e.Holder)
+}
+
+func (e *HeldError) Unwrap() error {
+	return ErrHeld
+}
+
+// Run acquires the Lease, calls fn while holding it and releases it when fn
+// returns. fn's context is cancelled if the lease is lost.
+func Run(ctx context.Context, kube kubernetes.Interface, cfg Config, fn func(ctx context.Context) error) error {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: cfg.Namespace, Name: cfg.Name},
+		Client:     kube.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		holder string
+		runErr error
+	)
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(lctx context.Context) {
+				close(started)
+				runErr = fn(lctx)
+				close(finished)
+				cancel()
+			},
+			OnStoppedLeading: func() {},
+			OnNewLeader: func(identity string) {
+				if identity == cfg.Identity || cfg.Wait {
+					return
+				}
+				mu.Lock()
+				holder = identity
+				mu.Unlock()
+				cancel()
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	le.Run(ctx)
+
+	select {
+	case <-finished:
+		return runErr
+	default:
+	}
+	select {
+	case <-started:
+		// The elector stopped renewing while fn was still running.
+		<-finished
+		return ErrLost
+	default:
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if holder != "" {
+		return &HeldError{Holder: holder}
+	}
+	return ctx.Err()
+}
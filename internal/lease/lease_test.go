@@ -0,0 +1,89 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testConfig(identity string, wait bool) Config {
+	return Config{
+		Namespace:     "default",
+		Name:          "k8s-cleanup",
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+		Wait:          wait,
+	}
+}
+
+func heldLease(holder string) *coordinationv1.Lease {
+	now := metav1.NewMicroTime(time.Now())
+	secs := int32(60)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "k8s-cleanup"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &secs,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+}
+
+func Test_Run_AcquiresAndReleases(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	called := false
+	err := Run(ctx, kube, testConfig("a", false), func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if err != nil || !called {
+		t.Fatalf("run: called=%v err=%v", called, err)
+	}
+	l, err := kube.CoordinationV1().Leases("default").Get(ctx, "k8s-cleanup", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if l.Spec.HolderIdentity != nil && *l.Spec.HolderIdentity != "" {
+		t.Fatalf("lease should be released, holder %q", *l.Spec.HolderIdentity)
+	}
+}
+
+func Test_Run_ReturnsHolderWhenHeld(t *testing.T) {
+	kube := fake.NewSimpleClientset(heldLease("other"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := Run(ctx, kube, testConfig("a", false), func(ctx context.Context) error {
+		t.Fatal("fn must not run while the lease is held")
+		return nil
+	})
+	var held *HeldError
+	if !errors.As(err, &held) || held.Holder != "other" || !errors.Is(err, ErrHeld) {
+		t.Fatalf("expected HeldError for other, got %v", err)
+	}
+}
+
+func Test_Run_WaitsForHolder(t *testing.T) {
+	kube := fake.NewSimpleClientset(heldLease("other"))
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	err := Run(ctx, kube, testConfig("a", true), func(ctx context.Context) error {
+		t.Fatal("fn must not run while the lease is held")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait until the deadline, got %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const namespace = "k8s_cleanup"
+
+// Metrics holds the collectors exposed by --metrics-addr. It implements
+// engine.Observer so List and Delete latency is recorded by the engine itself.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	candidates     *prometheus.CounterVec
+	attempted      *prometheus.CounterVec
+	succeeded      *prometheus.CounterVec
+	failed         *prometheus.CounterVec
+	listDuration   *prometheus.HistogramVec
+	deleteDuration *prometheus.HistogramVec
+	lastSuccess    prometheus.Gauge
+}
+
+func New() *Metrics {
+	labels := []string{"kind", "namespace", "state"}
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		candidates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "candidates_total",
+			Help:      "Resources selected for deletion.",
+		}, labels),
+		attempted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deletions_attempted_total",
+			Help:      "Delete calls issued.",
+		}, labels),
+		succeeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deletions_succeeded_total",
+			Help:      "Delete calls that succeeded.",
+		}, labels),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deletions_failed_total",
+			Help:      "Delete calls that failed.",
+		}, labels),
+		listDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "list_duration_seconds",
+			Help:      "Latency of List calls per kind.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"kind"}),
+		deleteDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delete_duration_seconds",
+			Help:      "Latency of Delete calls per kind.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"kind"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last run that finished without errors.",
+		}),
+	}
+	m.Registry.MustRegister(m.candidates, m.attempted, m.succeeded, m.failed,
+		m.listDuration, m.deleteDuration, m.lastSuccess)
+	return m
+}
+
+func (m *Metrics) ObserveList(kind, _ string, d time.Duration, _ error) {
+	m.listDuration.WithLabelValues(kind).Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveDelete(kind, _ string, d time.Duration, _ error) {
+	m.deleteDuration.WithLabelValues(kind).Observe(d.Seconds())
+}
+
+func (m *Metrics) Candidate(kind, ns, state string) {
+	m.candidates.WithLabelValues(kind, ns, state).Inc()
+}
+
+// Deletion records the outcome of one delete call.
+func (m *Metrics) Deletion(kind, ns, state string, err error) {
+	m.attempted.WithLabelValues(kind, ns, state).Inc()
+	if err != nil {
+		m.failed.WithLabelValues(kind, ns, state).Inc()
+		return
+	}
+	m.succeeded.WithLabelValues(kind, ns, state).Inc()
+}
+
+func (m *Metrics) RunSucceeded(t time.Time) {
+	m.lastSuccess.Set(float64(t.Unix()))
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes /metrics on addr until the returned stop func is called. The
+// server failing later is logged; the run carries on without it.
+func (m *Metrics) Serve(addr string) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("addr", addr).Msg("metrics server stopped")
+			_ = ln.Close()
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Handler_ExposesRecordedValues(t *testing.T) {
+	m := New()
+	m.Candidate("pod", "test", "Succeeded")
+	m.Candidate("pod", "test", "Succeeded")
+	m.Deletion("pod", "test", "Succeeded", nil)
+	m.Deletion("pod", "test", "Succeeded", errors.New("boom"))
+	m.ObserveList("pod", "test", 20*time.Millisecond, nil)
+	m.ObserveDelete("pod", "test", 5*time.Millisecond, nil)
+	m.RunSucceeded(time.Unix(1700000000, 0))
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		`k8s_cleanup_candidates_total{kind="pod",namespace="test",state="Succeeded"} 2`,
+		`k8s_cleanup_deletions_attempted_total{kind="pod",namespace="test",state="Succeeded"} 2`,
+		`k8s_cleanup_deletions_succeeded_total{kind="pod",namespace="test",state="Succeeded"} 1`,
+		`k8s_cleanup_deletions_failed_total{kind="pod",namespace="test",state="Succeeded"} 1`,
+		`k8s_cleanup_list_duration_seconds_count{kind="pod"} 1`,
+		`k8s_cleanup_delete_duration_seconds_count{kind="pod"} 1`,
+		`k8s_cleanup_last_success_timestamp_seconds 1.7e+09`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func Test_Serve_ListensAndStops(t *testing.T) {
+	m := New()
+	stop, err := m.Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	stop()
+}
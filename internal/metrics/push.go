@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Summary is what a single run reports to a Pushgateway.
+type Summary struct {
+	Candidates int
+	Deleted    int
+	Errors     int
+	Duration   time.Duration
+	DryRun     bool
+	// Failed is set when the run stopped early, e.g. because a list call
+	// failed, so not every candidate was handled.
+	Failed   bool
+	Finished time.Time
+	ByKind   map[string]KindSummary
+}
+
+type KindSummary struct {
+	Candidates int
+	Deleted    int
+	Errors     int
+}
+
+// Add counts one result into the summary.
+func (s *Summary) Add(kind string, deleted, failed bool) {
+	if s.ByKind == nil {
+		s.ByKind = map[string]KindSummary{}
+	}
+	k := s.ByKind[kind]
+	s.Candidates++
+	k.Candidates++
+	if failed {
+		s.Errors++
+		k.Errors++
+	} else if deleted {
+		s.Deleted++
+		k.Deleted++
+	}
+	s.ByKind[kind] = k
+}
+
+// AddKinds makes sure each kind is reported, with zeros when the run found
+// nothing of it. Per-kind series a push leaves out stay on the Pushgateway
+// from the previous run.
+func (s *Summary) AddKinds(kinds ...string) {
+	if s.ByKind == nil {
+		s.ByKind = map[string]KindSummary{}
+	}
+	for _, k := range kinds {
+		s.ByKind[k] = s.ByKind[k]
+	}
+}
+
+// PushSummary sends s to the Pushgateway at url under the given job and
+// instance. Metric families it pushes replace those of the previous run; the
+// last success timestamp is only pushed when the run had no errors and did
+// not fail, so it survives failing runs.
+func PushSummary(url, job, instance string, s Summary) error {
+	reg := prometheus.NewRegistry()
+	gauge := func(name, help string, v float64) {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help})
+		g.Set(v)
+		reg.MustRegister(g)
+	}
+	dry, failed := 0.0, 0.0
+	if s.DryRun {
+		dry = 1
+	}
+	if s.Failed {
+		failed = 1
+	}
+	gauge("run_candidates", "Candidates found by the last run.", float64(s.Candidates))
+	gauge("run_deleted", "Resources deleted by the last run.", float64(s.Deleted))
+	gauge("run_errors", "Failed deletions in the last run.", float64(s.Errors))
+	gauge("run_duration_seconds", "Duration of the last run.", s.Duration.Seconds())
+	gauge("run_dry_run", "1 if the last run was a dry-run.", dry)
+	gauge("run_failed", "1 if the last run stopped early, e.g. because listing failed.", failed)
+	gauge("run_finished_timestamp_seconds", "Unix time the last run finished.", float64(s.Finished.Unix()))
+	if s.Errors == 0 && !s.Failed {
+		gauge("last_success_timestamp_seconds", "Unix time of the last run that finished without errors.", float64(s.Finished.Unix()))
+	}
+
+	byKind := func(name, help string, v func(KindSummary) int) {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help}, []string{"kind"})
+		kinds := make([]string, 0, len(s.ByKind))
+		for k := range s.ByKind {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		for _, k := range kinds {
+			g.WithLabelValues(k).Set(float64(v(s.ByKind[k])))
+		}
+		reg.MustRegister(g)
+	}
+	byKind("run_kind_candidates", "Candidates found by the last run per kind.", func(k KindSummary) int { return k.Candidates })
+	byKind("run_kind_deleted", "Resources deleted by the last run per kind.", func(k KindSummary) int { return k.Deleted })
+	byKind("run_kind_errors", "Failed deletions in the last run per kind.", func(k KindSummary) int { return k.Errors })
+
+	return push.New(url, job).
+		Grouping("instance", instance).
+		Client(&http.Client{Timeout: 10 * time.Second}).
+		Gatherer(reg).
+		Add()
+}
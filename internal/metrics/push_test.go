@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+type pushed struct {
+	method   string
+	path     string
+	families map[string]*dto.MetricFamily
+}
+
+func pushgateway(t *testing.T) (*httptest.Server, *pushed) {
+	t.Helper()
+	got := &pushed{families: map[string]*dto.MetricFamily{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path = r.Method, r.URL.Path
+		br := bufio.NewReader(r.Body)
+		for {
+			mf := &dto.MetricFamily{}
+			if err := protodelim.UnmarshalFrom(br, mf); err != nil {
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("decode: %v", err)
+				}
+				break
+			}
+			got.families[mf.GetName()] = mf
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func gaugeValue(t *testing.T, p *pushed, name, kind string) float64 {
+	t.Helper()
+	mf, ok := p.families[name]
+	if !ok {
+		t.Fatalf("metric %s not pushed", name)
+	}
+	for _, m := range mf.GetMetric() {
+		match := kind == ""
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "kind" && l.GetValue() == kind {
+				match = true
+			}
+		}
+		if match {
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("metric %s has no series for kind %q", name, kind)
+	return 0
+}
+
+func Test_PushSummary_SendsRunSummary(t *testing.T) {
+	srv, got := pushgateway(t)
+
+	var s Summary
+	s.Add("pod", true, false)
+	s.Add("pod", false, true)
+	s.Add("job", true, false)
+	s.Duration = 1500 * time.Millisecond
+	s.Finished = time.Unix(1700000000, 0)
+
+	if err := PushSummary(srv.URL, "k8s-cleanup", "node-1", s); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if got.method != http.MethodPost || got.path != "/metrics/job/k8s-cleanup/instance/node-1" {
+		t.Fatalf("unexpected request %s %s", got.method, got.path)
+	}
+	for name, want := range map[string]float64{
+		"k8s_cleanup_run_candidates":                 3,
+		"k8s_cleanup_run_deleted":                    2,
+		"k8s_cleanup_run_errors":                     1,
+		"k8s_cleanup_run_duration_seconds":           1.5,
+		"k8s_cleanup_run_dry_run":                    0,
+		"k8s_cleanup_run_finished_timestamp_seconds": 1700000000,
+	} {
+		if v := gaugeValue(t, got, name, ""); v != want {
+			t.Fatalf("%s = %v, want %v", name, v, want)
+		}
+	}
+	if v := gaugeValue(t, got, "k8s_cleanup_run_kind_errors", "pod"); v != 1 {
+		t.Fatalf("pod errors = %v, want 1", v)
+	}
+	if v := gaugeValue(t, got, "k8s_cleanup_run_kind_deleted", "job"); v != 1 {
+		t.Fatalf("job deleted = %v, want 1", v)
+	}
+	if _, ok := got.families["k8s_cleanup_last_success_timestamp_seconds"]; ok {
+		t.Fatal("last success must not be pushed for a run with errors")
+	}
+}
+
+func Test_PushSummary_DryRunSuccess(t *testing.T) {
+	srv, got := pushgateway(t)
+	s := Summary{DryRun: true, Finished: time.Unix(1700000000, 0)}
+	s.Add("pod", false, false)
+
+	if err := PushSummary(srv.URL, "k8s-cleanup", "node-1", s); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if v := gaugeValue(t, got, "k8s_cleanup_run_dry_run", ""); v != 1 {
+		t.Fatalf("dry run = %v, want 1", v)
+	}
+	if v := gaugeValue(t, got, "k8s_cleanup_last_success_timestamp_seconds", ""); v != 1700000000 {
+		t.Fatalf("last success = %v", v)
+	}
+}
+
+func Test_PushSummary_ReportsGatewayErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	if err := PushSummary(srv.URL, "k8s-cleanup", "node-1", Summary{}); err == nil {
+		t.Fatal("expected error from failing pushgateway")
+	}
+}
+
+func Test_PushSummary_ZeroesKindsWithoutCandidates(t *testing.T) {
+	srv, got := pushgateway(t)
+
+	var s Summary
+	s.AddKinds("pod", "job")
+	s.Add("pod", true, false)
+	s.Finished = time.Unix(1700000000, 0)
+	if err := PushSummary(srv.URL, "k8s-cleanup", "k8s-cleanup", s); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if v := gaugeValue(t, got, "k8s_cleanup_run_kind_deleted", "pod"); v != 1 {
+		t.Fatalf("pod deleted = %v, want 1", v)
+	}
+	for _, name := range []string{"k8s_cleanup_run_kind_candidates", "k8s_cleanup_run_kind_deleted", "k8s_cleanup_run_kind_errors"} {
+		if v := gaugeValue(t, got, name, "job"); v != 0 {
+			t.Fatalf("%s for job = %v, want an explicit 0", name, v)
+		}
+	}
+	if s.Candidates != 1 {
+		t.Fatalf("candidates = %d, want 1", s.Candidates)
+	}
+}
@@ -0,0 +1,179 @@
+// Package snapshot loads manifests saved from a cluster into fake clients so
+// policies can be evaluated offline.
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	dynfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// Snapshot is every object read from a set of manifests.
+type Snapshot struct {
+	Objects []*unstructured.Unstructured
+}
+
+// Load reads a manifest file, or every .yaml, .yml and .json file below a
+// directory in path order. Files may hold several YAML documents and Lists,
+// e.g. the output of kubectl get -o yaml.
+func Load(p string) (*Snapshot, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if !fi.IsDir() {
+		return s, s.readFile(p, p)
+	}
+	err = filepath.WalkDir(p, func(file string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isManifest(file) {
+			return err
+		}
+		rel, err := filepath.Rel(p, file)
+		if err != nil {
+			return err
+		}
+		return s.readFile(file, filepath.ToSlash(rel))
+	})
+	return s, err
+}
+
+func isManifest(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func (s *Snapshot) readFile(file, name string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	dec := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for doc := 1; ; doc++ {
+		var m map[string]interface{}
+		if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: document %d: %w", name, doc, err)
+		}
+		if len(m) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{Object: m}
+		if !u.IsList() {
+			if err := s.add(u); err != nil {
+				return fmt.Errorf("%s: document %d: %w", name, doc, err)
+			}
+			continue
+		}
+		l, err := u.ToList()
+		if err != nil {
+			return fmt.Errorf("%s: document %d: %w", name, doc, err)
+		}
+		for i := range l.Items {
+			if err := s.add(&l.Items[i]); err != nil {
+				return fmt.Errorf("%s: document %d: item %d: %w", name, doc, i, err)
+			}
+		}
+	}
+}
+
+func (s *Snapshot) add(u *unstructured.Unstructured) error {
+	if u.GetAPIVersion() == "" || u.GetKind() == "" {
+		return fmt.Errorf("apiVersion and kind are required")
+	}
+	if u.GetName() == "" {
+		return fmt.Errorf("%s has no name", u.GetKind())
+	}
+	s.Objects = append(s.Objects, u)
+	return nil
+}
+
+// Clients returns fake clients serving the snapshot. Built-in types are served
+// by the typed clientset, everything else by the dynamic client and mapper.
+// Namespaces that objects live in but that are not in the snapshot are added.
+func (s *Snapshot) Clients() (kubernetes.Interface, dynamic.Interface, meta.RESTMapper, error) {
+	kube := fake.NewSimpleClientset()
+	mapper := meta.NewDefaultRESTMapper(nil)
+	listKinds := map[schema.GroupVersionResource]string{}
+	var custom []*unstructured.Unstructured
+	namespaces := map[string]bool{}
+	var used []string
+
+	for _, u := range s.Objects {
+		gvk := u.GroupVersionKind()
+		if ns := u.GetNamespace(); ns != "" {
+			if _, ok := namespaces[ns]; !ok {
+				namespaces[ns] = false
+				used = append(used, ns)
+			}
+		}
+		if !scheme.Scheme.Recognizes(gvk) {
+			scope := meta.RESTScopeRoot
+			if u.GetNamespace() != "" {
+				scope = meta.RESTScopeNamespace
+			}
+			mapper.Add(gvk, scope)
+			gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+			listKinds[gvr] = gvk.Kind + "List"
+			custom = append(custom, u)
+			continue
+		}
+		obj, err := scheme.Scheme.New(gvk)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s %s: %w", gvk.Kind, objectName(u), err)
+		}
+		if err := kube.Tracker().Add(obj); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s %s: %w", gvk.Kind, objectName(u), err)
+		}
+		if gvk.Group == "" && gvk.Kind == "Namespace" {
+			namespaces[u.GetName()] = true
+		}
+	}
+	for _, ns := range used {
+		if namespaces[ns] {
+			continue
+		}
+		if err := kube.Tracker().Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	dyn := dynfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	for _, u := range custom {
+		if err := dyn.Tracker().Add(u); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s %s: %w", u.GetKind(), objectName(u), err)
+		}
+	}
+	return kube, dyn, mapper, nil
+}
+
+func objectName(u *unstructured.Unstructured) string {
+	if ns := u.GetNamespace(); ns != "" {
+		return ns + "/" + u.GetName()
+	}
+	return u.GetName()
+}
@@ -0,0 +1,85 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const podsList = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata: {name: a, namespace: ci}
+  status: {phase: Succeeded}
+- apiVersion: v1
+  kind: Pod
+  metadata: {name: b, namespace: ci}
+---
+apiVersion: batch/v1
+kind: Job
+metadata: {name: j, namespace: web}
+`
+
+const workflow = `{"apiVersion": "argoproj.io/v1alpha1", "kind": "Workflow", "metadata": {"name": "wf", "namespace": "ci"}}`
+
+func Test_Load_ListsDocumentsAndCustomResources(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pods.yaml"), []byte(podsList), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "argo"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "argo", "wf.json"), []byte(workflow), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a manifest"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Objects) != 4 {
+		t.Fatalf("loaded %d objects, want 4", len(s.Objects))
+	}
+	kube, dyn, mapper, err := s.Clients()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	pods, err := kube.CoreV1().Pods("ci").List(ctx, metav1.ListOptions{})
+	if err != nil || len(pods.Items) != 2 || pods.Items[0].Status.Phase == "" {
+		t.Fatalf("pods %+v, %v", pods, err)
+	}
+	nss, err := kube.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil || len(nss.Items) != 2 {
+		t.Fatalf("namespaces %+v, %v, want ci and web added", nss, err)
+	}
+	gvr, err := mapper.ResourceFor(schema.GroupVersionResource{Group: "argoproj.io", Resource: "workflows"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wfs, err := dyn.Resource(gvr).Namespace("ci").List(ctx, metav1.ListOptions{})
+	if err != nil || len(wfs.Items) != 1 {
+		t.Fatalf("workflows %+v, %v", wfs, err)
+	}
+}
+
+func Test_Load_RejectsObjectsWithoutKind(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bad.yaml")
+	if err := os.WriteFile(file, []byte("metadata: {name: x}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Fatal("want an error for a manifest without apiVersion and kind")
+	}
+}